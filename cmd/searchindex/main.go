@@ -0,0 +1,71 @@
+// The searchindex command rebuilds the in-process search index used by the
+// web application's -search-index option from the snippets in the database.
+//
+// The web application keeps its own copy of the index in memory and writes it
+// back to disk periodically, so it should be stopped while the index is being
+// rebuilt. Otherwise it will overwrite the rebuilt index with its own.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/search"
+)
+
+func main() {
+	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
+	indexPath := flag.String("index", "snippets.idx", "Path of the search index file to write")
+
+	flag.Parse()
+
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer db.Close()
+
+	// We start from an empty in-memory index rather than loading the old one,
+	// since every document is about to be replaced anyway.
+	index, err := search.New(search.MemoryStore{})
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	start := time.Now()
+
+	n, err := search.RebuildSnippets(index, &models.SnippetModel{DB: db})
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	store := &search.FileStore{Path: *indexPath}
+
+	err = index.SaveTo(store)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	infoLog.Printf("Indexed %d snippets into %s in %s", n, *indexPath, time.Since(start).Round(time.Millisecond))
+}
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/validator"
+)
+
+// An apiSnippet is a snippet as it appears in API responses. Content is left
+// out of lists, in the same way as the HTML listing pages only show titles,
+// so that view limits can't be bypassed.
+type apiSnippet struct {
+	Slug string `json:"slug"`
+	URL string `json:"url"`
+	Title string `json:"title"`
+	Content string `json:"content,omitempty"`
+	Language string `json:"language"`
+	Format string `json:"format"`
+	Created time.Time `json:"created"`
+	// Expires is null for snippets which never expire.
+	Expires *time.Time `json:"expires"`
+	Visibility string `json:"visibility"`
+	Views int `json:"views"`
+	MaxViews int `json:"max_views"`
+	Owner string `json:"owner,omitempty"`
+	Tags []string `json:"tags"`
+}
+
+// The newAPISnippet method converts a snippet for an API response, including
+// its content if withContent is true.
+func (app *application) newAPISnippet(snippet *models.Snippet, withContent bool) apiSnippet {
+	s := apiSnippet{
+		Slug: snippet.Slug,
+		URL: fmt.Sprintf("%s/snippet/view/%s", app.baseURL, snippet.Slug),
+		Title: snippet.Title,
+		Language: snippet.Language,
+		Format: snippet.Format,
+		Created: snippet.Created,
+		Visibility: snippet.Visibility,
+		Views: snippet.Views,
+		MaxViews: snippet.MaxViews,
+		Owner: snippet.UserName,
+		Tags: snippet.Tags,
+	}
+	if withContent {
+		s.Content = snippet.Content
+	}
+	if !snippet.Expires.IsZero() {
+		s.Expires = &snippet.Expires
+	}
+	if s.Tags == nil {
+		s.Tags = []string{}
+	}
+
+	return s
+}
+
+// An apiSnippetInput holds the JSON body of a request to create or update a
+// snippet. It has the same fields as snippetCreateForm, except that tags are
+// given as an array rather than a comma-separated string.
+type apiSnippetInput struct {
+	Title string `json:"title"`
+	Content string `json:"content"`
+	Language string `json:"language"`
+	Format string `json:"format"`
+	Expires string `json:"expires"`
+	ExpiresAt string `json:"expires_at"`
+	Visibility string `json:"visibility"`
+	BurnAfterReading bool `json:"burn"`
+	MaxViews int `json:"max_views"`
+	Tags []string `json:"tags"`
+}
+
+// newAPISnippetInput returns an apiSnippetInput holding the same values as a
+// form, so that a request body can be decoded over the top of them.
+func newAPISnippetInput(form snippetCreateForm) apiSnippetInput {
+	return apiSnippetInput{
+		Title: form.Title,
+		Content: form.Content,
+		Language: form.Language,
+		Format: form.Format,
+		Expires: form.Expires,
+		ExpiresAt: form.ExpiresAt,
+		Visibility: form.Visibility,
+		BurnAfterReading: form.BurnAfterReading,
+		MaxViews: form.MaxViews,
+		Tags: models.ParseTags(form.Tags),
+	}
+}
+
+// The form method converts the input into a snippetCreateForm, ready to be
+// validated.
+func (input apiSnippetInput) form() snippetCreateForm {
+	return snippetCreateForm{
+		Title: input.Title,
+		Content: input.Content,
+		Language: input.Language,
+		Format: input.Format,
+		Expires: input.Expires,
+		ExpiresAt: input.ExpiresAt,
+		Visibility: input.Visibility,
+		BurnAfterReading: input.BurnAfterReading,
+		MaxViews: input.MaxViews,
+		Tags: strings.Join(input.Tags, ","),
+	}
+}
+
+// The apiSnippetList handler returns a page of public snippets. It takes the
+// same query string parameters as the /snippets page, plus an optional tag.
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	form := newSnippetListForm()
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.apiErrorResponse(w, http.StatusBadRequest, "the query string could not be decoded")
+		return
+	}
+
+	tag := r.URL.Query().Get("tag")
+
+	form.validate()
+	form.CheckField(tag == "" || validator.Matches(tag, validator.TagRX), "tag", "Tags can only contain letters, numbers and the characters + - .")
+
+	if !form.Valid() {
+		app.apiValidationError(w, form.Validator)
+		return
+	}
+
+	opts, err := form.options(tag)
+	if err != nil {
+		app.apiErrorResponse(w, http.StatusBadRequest, "the cursor is not valid")
+		return
+	}
+
+	page, err := app.snippets.List(opts)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	snippets := make([]apiSnippet, len(page.Snippets))
+	for i, snippet := range page.Snippets {
+		snippets[i] = app.newAPISnippet(snippet, false)
+	}
+
+	// Cursors are null when there's no page in that direction.
+	var next, prev *string
+	if page.Next != nil {
+		cursor := page.Next.Encode()
+		next = &cursor
+	}
+	if page.Prev != nil {
+		cursor := page.Prev.Encode()
+		prev = &cursor
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"snippets": snippets, "next": next, "prev": prev})
+}
+
+// The apiSnippetView handler returns a single snippet with its content. Just
+// like the view page, this counts as a view of snippets with a view limit.
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	snippet, err := app.snippets.Get(params.ByName("slug"))
+	if err == nil {
+		snippet, err = app.countView(w, r, snippet)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"snippet": app.newAPISnippet(snippet, true)})
+}
+
+// The apiSnippetCreate handler creates a snippet owned by the current user.
+// Any fields left out of the body take the same defaults as the create form.
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	unverified, err := app.needsVerification(r)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	if unverified {
+		app.apiErrorResponse(w, http.StatusForbidden, "you must verify your email address before creating snippets")
+		return
+	}
+
+	input := newAPISnippetInput(snippetCreateForm{
+		Language: "auto",
+		Format: models.FormatText,
+		Expires: "365d",
+		Visibility: models.VisibilityPublic,
+	})
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form := input.form()
+	form.validate()
+
+	if !form.Valid() {
+		app.apiValidationError(w, form.Validator)
+		return
+	}
+
+	slug, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, form.Content, form.language, form.Format, form.expiry, form.Visibility, form.MaxViews, form.tags)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	// The snippet is fetched again so that the response includes everything
+	// the database filled in, such as its creation time.
+	snippet, err := app.snippets.Get(slug)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/snippets/%s", slug))
+	app.writeJSON(w, http.StatusCreated, envelope{"snippet": app.newAPISnippet(snippet, true)})
+}
+
+// The apiSnippetUpdate handler changes a snippet belonging to the current
+// user. Only the fields given in the body are changed, and the rest keep
+// their current values.
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	// The requireAPISnippetOwner middleware has already loaded the snippet and
+	// checked that it belongs to the current user.
+	snippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+
+	input := newAPISnippetInput(newSnippetEditForm(snippet))
+	currentExpiresAt := input.ExpiresAt
+
+	// burn is just a shortcut for a view limit of 1, which max_views
+	// already holds. Leaving it set would override any new max_views.
+	input.BurnAfterReading = false
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.apiErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The expiry is kept unless it's changed, but giving just a new
+	// expires_at is enough to change it.
+	if input.Expires == "keep" && input.ExpiresAt != currentExpiresAt {
+		input.Expires = "custom"
+	}
+
+	form := input.form()
+	form.currentExpiry = &snippet.Expires
+	form.validate()
+
+	if !form.Valid() {
+		app.apiValidationError(w, form.Validator)
+		return
+	}
+
+	err = app.snippets.Update(snippet.ID, app.authenticatedUserID(r), form.Title, form.Content, form.language, form.Format, form.expiry, form.Visibility, form.MaxViews, form.tags)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	snippet, err = app.snippets.Get(snippet.Slug)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			// The new expiry time may already have passed.
+			app.apiNotFound(w)
+		} else {
+			app.apiServerError(w, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"snippet": app.newAPISnippet(snippet, true)})
+}
+
+// The apiSnippetDelete handler moves a snippet belonging to the current user
+// to the trash, where it can still be restored from the website.
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+
+	err := app.snippets.Delete(snippet.ID)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// An apiUser is a user as it appears in API responses.
+type apiUser struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+	Email string `json:"email"`
+	Created time.Time `json:"created"`
+	EmailVerified bool `json:"email_verified"`
+}
+
+// The apiMe handler returns the details of the current user.
+func (app *application) apiMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, envelope{"user": apiUser{
+		ID: user.ID,
+		Name: user.Name,
+		Email: user.Email,
+		Created: user.Created,
+		EmailVerified: user.EmailVerified,
+	}})
+}
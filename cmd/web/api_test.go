@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"snippetbox.jamespaul.com/internal/highlight"
+	"snippetbox.jamespaul.com/internal/models"
+)
+
+func TestAPISnippetUpdateViewLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxViews int
+		body     string
+		want     int
+	}{
+		{
+			name:     "Raise the limit of a burn after reading snippet",
+			maxViews: 1,
+			body:     `{"max_views": 5}`,
+			want:     5,
+		},
+		{
+			name:     "Remove the limit of a burn after reading snippet",
+			maxViews: 1,
+			body:     `{"max_views": 0}`,
+			want:     0,
+		},
+		{
+			name:     "Keep burn after reading",
+			maxViews: 1,
+			body:     `{"title": "New title"}`,
+			want:     1,
+		},
+		{
+			name:     "Turn on burn after reading",
+			maxViews: 5,
+			body:     `{"burn": true}`,
+			want:     1,
+		},
+		{
+			name:     "Keep the limit",
+			maxViews: 5,
+			body:     `{"title": "New title"}`,
+			want:     5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			snippet := &models.Snippet{
+				ID:         1,
+				Slug:       "stub1",
+				Title:      "Title",
+				Content:    "Content",
+				Language:   highlight.Plaintext,
+				Format:     models.FormatText,
+				Visibility: models.VisibilityPublic,
+				MaxViews:   tt.maxViews,
+				UserID:     1,
+			}
+			stored := *snippet
+			app.snippets.(*stubSnippetModel).snippets = []*models.Snippet{&stored}
+
+			// Set up the request as the API authentication and
+			// requireAPISnippetOwner middleware would.
+			r := httptest.NewRequest(http.MethodPatch, "/api/v1/snippets/stub1", strings.NewReader(tt.body))
+			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, 1)
+			ctx = context.WithValue(ctx, snippetContextKey, snippet)
+
+			rr := httptest.NewRecorder()
+			app.apiSnippetUpdate(rr, r.WithContext(ctx))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+			}
+
+			var response struct {
+				Snippet struct {
+					URL      string `json:"url"`
+					MaxViews int    `json:"max_views"`
+				} `json:"snippet"`
+			}
+			err := json.NewDecoder(rr.Body).Decode(&response)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stored.MaxViews != tt.want {
+				t.Errorf("got stored max_views %d; want %d", stored.MaxViews, tt.want)
+			}
+			if response.Snippet.MaxViews != tt.want {
+				t.Errorf("got max_views %d in the response; want %d", response.Snippet.MaxViews, tt.want)
+			}
+
+			// The request was made to example.com, but the URL should
+			// use the configured base URL.
+			if want := "https://snippetbox.example.com/snippet/view/stub1"; response.Snippet.URL != want {
+				t.Errorf("got url %q; want %q", response.Snippet.URL, want)
+			}
+		})
+	}
+}
@@ -2,4 +2,17 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
\ No newline at end of file
+const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// authenticatedUserIDContextKey holds the ID of the user making the request,
+// once the authenticate middleware has confirmed that they exist.
+const authenticatedUserIDContextKey = contextKey("authenticatedUserID")
+
+// snippetContextKey holds the snippet loaded by the requireSnippetOwner
+// middleware, so that handlers further down the chain don't need to fetch it
+// again.
+const snippetContextKey = contextKey("snippet")
+
+// apiTokenContextKey holds the API token used to authenticate a request, once
+// the authenticateToken middleware has checked it.
+const apiTokenContextKey = contextKey("apiToken")
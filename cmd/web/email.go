@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"snippetbox.jamespaul.com/internal/mailer"
+)
+
+// newEmailTemplateCache parses the email templates in ui/email. Each one
+// defines a "subject" and a "body" template. They're plain text, so they use
+// text/template rather than html/template.
+func newEmailTemplateCache() (map[string]*template.Template, error) {
+	cache := map[string]*template.Template{}
+
+	files, err := filepath.Glob("./ui/email/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		ts, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, err
+		}
+
+		cache[filepath.Base(file)] = ts
+	}
+
+	return cache, nil
+}
+
+// The sendEmail helper renders an email template with the given data and
+// sends the result to a single recipient. The message is sent in the
+// background, so that a slow mail server doesn't hold up the response, and
+// any error is logged rather than returned.
+func (app *application) sendEmail(to, name string, data any) error {
+	ts, ok := app.emailTemplates[name]
+	if !ok {
+		return fmt.Errorf("the email template %s does not exist", name)
+	}
+
+	subject := new(bytes.Buffer)
+	err := ts.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return err
+	}
+
+	body := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(body, "body", data)
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To: to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body: strings.TrimSpace(body.String()) + "\n",
+	}
+
+	app.background("email", func() {
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("email: %s", err))
+		}
+	})
+
+	return nil
+}
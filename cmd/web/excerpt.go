@@ -0,0 +1,158 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"snippetbox.jamespaul.com/internal/models"
+)
+
+// excerptLength is roughly how many bytes of a snippet's content are shown in
+// each search result.
+const excerptLength = 240
+
+// The searchResult type pairs a snippet with HTML for displaying it in a list
+// of search results, with the matching words highlighted. Title and Excerpt
+// are escaped by markMatches() before the <mark> tags are added, which is why
+// it's safe for them to be template.HTML.
+type searchResult struct {
+	Snippet *models.Snippet
+	Title template.HTML
+	Excerpt template.HTML
+}
+
+// matchRegexp returns a case-insensitive regular expression matching any of
+// the terms or phrases in a search query, or nil if the query is empty. It
+// doesn't check for word boundaries, since Go's \b only knows about ASCII
+// letters, so matches should be found with findMatches() rather than used
+// directly. Longer alternatives come first, so that a term which is the
+// start of another doesn't hide it.
+func matchRegexp(q models.SearchQuery) *regexp.Regexp {
+	var alternatives []string
+
+	for _, term := range q.Terms {
+		alternatives = append(alternatives, regexp.QuoteMeta(term))
+	}
+	for _, phrase := range q.Phrases {
+		// The words of a phrase may be separated by any whitespace or
+		// punctuation in the original text.
+		words := strings.Fields(phrase)
+		for i := range words {
+			words[i] = regexp.QuoteMeta(words[i])
+		}
+		alternatives = append(alternatives, strings.Join(words, `[^\pL\pN_]+`))
+	}
+
+	if len(alternatives) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(alternatives, func(i, j int) bool {
+		return len(alternatives[i]) > len(alternatives[j])
+	})
+
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(alternatives, "|") + `)`)
+}
+
+// isWordRune reports whether r is part of a word, in the same way as MySQL's
+// FULLTEXT parser sees it.
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// findMatches returns the start and end of each match of rx in s which is a
+// whole word or words, meaning it isn't preceded or followed by a letter,
+// number or underscore. Unlike \b this works for words in any script.
+func findMatches(s string, rx *regexp.Regexp) [][]int {
+	if rx == nil {
+		return nil
+	}
+
+	var matches [][]int
+
+	for pos := 0; pos < len(s); {
+		loc := rx.FindStringIndex(s[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[0], pos+loc[1]
+
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+
+		if end > start && (start == 0 || !isWordRune(before)) && (end == len(s) || !isWordRune(after)) {
+			matches = append(matches, []int{start, end})
+			pos = end
+			continue
+		}
+
+		// This match was part of a longer word, so look again from the
+		// next character rather than skipping past it, in case a real
+		// match overlaps it.
+		_, size := utf8.DecodeRuneInString(s[start:])
+		pos = start + size
+	}
+
+	return matches
+}
+
+// markMatches HTML-escapes s, wrapping any matches of rx in <mark> tags.
+func markMatches(s string, rx *regexp.Regexp) template.HTML {
+	if rx == nil {
+		return template.HTML(html.EscapeString(s))
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, loc := range findMatches(s, rx) {
+		b.WriteString(html.EscapeString(s[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(s[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+
+	return template.HTML(b.String())
+}
+
+// excerpt returns a highlighted extract of content centred on the first match
+// of rx, with an ellipsis marking any text that has been cut off.
+func excerpt(content string, rx *regexp.Regexp) template.HTML {
+	start := 0
+	if matches := findMatches(content, rx); matches != nil {
+		start = matches[0][0] - excerptLength/3
+	}
+
+	if start < 0 {
+		start = 0
+	}
+	end := start + excerptLength
+	if end > len(content) {
+		end = len(content)
+	}
+
+	// Don't cut a multi-byte character in half.
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+
+	s := markMatches(content[start:end], rx)
+	if start > 0 {
+		s = "&hellip;" + s
+	}
+	if end < len(content) {
+		s += "&hellip;"
+	}
+
+	return s
+}
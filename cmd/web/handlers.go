@@ -3,11 +3,23 @@ package main
 import (
 	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
+	"rsc.io/qr"
+	"snippetbox.jamespaul.com/internal/diff"
+	"snippetbox.jamespaul.com/internal/highlight"
+	"snippetbox.jamespaul.com/internal/markdown"
 	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/search"
+	"snippetbox.jamespaul.com/internal/totp"
 	"snippetbox.jamespaul.com/internal/validator"
 )
 
@@ -23,8 +35,264 @@ import (
 type snippetCreateForm struct {
 	Title string `form:"title"` 
 	Content string `form:"content"` 
-	Expires int `form:"expires"` 
+	Language string `form:"language"`
+	Format string `form:"format"`
+	Expires string `form:"expires"` 
+	ExpiresAt string `form:"expires_at"`
+	Visibility string `form:"visibility"`
+	BurnAfterReading bool `form:"burn"`
+	MaxViews int `form:"max_views"`
+	Tags string `form:"tags"`
 	validator.Validator `form:"-"`
+	// expiry is the expiry time worked out by validate(), or the zero time if
+	// the snippet should never expire.
+	expiry time.Time
+	// tags holds the individual tags parsed from Tags by validate().
+	tags []string
+	// language is the language to store, which is detected from the content
+	// by validate() when Language is "auto".
+	language string
+	// currentExpiry is the expiry time of the snippet being edited, which
+	// is kept as it is when Expires is "keep". It's nil when creating a
+	// snippet, where there's nothing to keep.
+	currentExpiry *time.Time
+}
+
+// The CanKeepExpiry method reports whether the form is for editing a snippet,
+// and so offers to keep its current expiry time.
+func (form snippetCreateForm) CanKeepExpiry() bool {
+	return form.currentExpiry != nil
+}
+
+// expiryDurations maps the relative expiry options on the snippet form to
+// how far in the future they are. The form also accepts "never" and "custom",
+// where the latter means an absolute time is given in the ExpiresAt field,
+// and "keep" when editing a snippet.
+var expiryDurations = map[string]time.Duration{
+	"10m": 10 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+	"7d": 7 * 24 * time.Hour,
+	"365d": 365 * 24 * time.Hour,
+}
+
+// expiresAtLayout is the format used by <input type='datetime-local'>. Times
+// entered in this field are treated as UTC.
+const expiresAtLayout = "2006-01-02T15:04"
+
+// The validate method runs the checks shared by the create and edit forms,
+// recording any problems in the embedded Validator.
+func (form *snippetCreateForm) validate() {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank") 
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long") 
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank") 
+
+	if form.Language == "auto" {
+		form.language = highlight.Detect(form.Content)
+	} else {
+		form.CheckField(highlight.Valid(form.Language), "language", "This field must be one of the listed languages")
+		form.language = form.Language
+	}
+	form.CheckField(validator.PermittedValue(form.Format, models.FormatText, models.FormatMarkdown), "format", "This field must be text or markdown")
+
+	switch form.Expires {
+	case "keep":
+		// The current expiry time isn't checked again, since it may have
+		// been set a long time ago and be about to pass.
+		form.CheckField(form.CanKeepExpiry(), "expires", "This field must be one of the listed options")
+		if form.currentExpiry != nil {
+			form.expiry = *form.currentExpiry
+		}
+	case "never":
+		form.expiry = time.Time{}
+	case "custom":
+		expiry, err := time.ParseInLocation(expiresAtLayout, form.ExpiresAt, time.UTC)
+		if err != nil {
+			form.AddFieldError("expires_at", "This field must be a valid date and time")
+			break
+		}
+		form.CheckField(expiry.After(time.Now()), "expires_at", "This field must be in the future")
+		form.expiry = expiry
+	default:
+		duration, ok := expiryDurations[form.Expires]
+		form.CheckField(ok, "expires", "This field must be one of the listed options")
+		form.expiry = time.Now().UTC().Add(duration)
+	}
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must be public, unlisted or private")
+
+	// Burning after reading is just a view limit of one.
+	if form.BurnAfterReading {
+		form.MaxViews = 1
+	}
+	form.CheckField(form.MaxViews >= 0 && form.MaxViews <= 1000, "max_views", "This field must be between 0 and 1000")
+
+	form.tags = models.ParseTags(form.Tags)
+	form.CheckField(validator.MaxItems(form.tags, models.MaxTags), "tags", fmt.Sprintf("This field cannot have more than %d tags", models.MaxTags))
+	form.CheckField(validator.AllMaxChars(form.tags, models.MaxTagLength), "tags", fmt.Sprintf("Each tag cannot be more than %d characters long", models.MaxTagLength))
+	form.CheckField(validator.AllMatch(form.tags, validator.TagRX), "tags", "Tags can only contain letters, numbers and the characters + - .")
+}
+
+// Create a new snippetListForm struct to hold the sorting and paging options
+// for the /snippets page. Unlike our other forms these come from the query
+// string, since the page is fetched with GET requests.
+type snippetListForm struct {
+	Sort string `form:"sort"`
+	Order string `form:"order"`
+	Size int `form:"size"`
+	After string `form:"after"`
+	Before string `form:"before"`
+	validator.Validator `form:"-"`
+}
+
+// newSnippetListForm returns a snippetListForm holding the default options,
+// ready for any values given in the query string to be decoded over the top.
+func newSnippetListForm() snippetListForm {
+	return snippetListForm{
+		Sort: models.SortCreated,
+		Order: "desc",
+		Size: 10,
+	}
+}
+
+// The validate method checks the sorting and paging options, recording any
+// problems in the embedded Validator.
+func (form *snippetListForm) validate() {
+	form.CheckField(validator.PermittedValue(form.Sort, models.SortCreated, models.SortExpires), "sort", "This field must be created or expires")
+	form.CheckField(validator.PermittedValue(form.Order, "asc", "desc"), "order", "This field must be asc or desc")
+	form.CheckField(validator.PermittedValue(form.Size, 10, 25, 50), "size", "This field must equal 10, 25 or 50")
+}
+
+// The options method converts a validated form into the options for
+// SnippetModel.List, limited to snippets with the given tag unless it is
+// empty. It returns models.ErrInvalidCursor if either cursor is malformed.
+func (form *snippetListForm) options(tag string) (models.ListOptions, error) {
+	opts := models.ListOptions{
+		Sort: form.Sort,
+		Descending: form.Order == "desc",
+		PageSize: form.Size,
+		Tag: tag,
+	}
+
+	if form.After != "" {
+		cursor, err := models.DecodeCursor(form.After)
+		if err != nil {
+			return opts, err
+		}
+		opts.After = &cursor
+	} else if form.Before != "" {
+		cursor, err := models.DecodeCursor(form.Before)
+		if err != nil {
+			return opts, err
+		}
+		opts.Before = &cursor
+	}
+
+	return opts, nil
+}
+
+// Create a new searchForm struct to hold the query string parameters for the
+// /search page.
+type searchForm struct {
+	Q string `form:"q"`
+	Page int `form:"page"`
+	validator.Validator `form:"-"`
+}
+
+// searchPageSize is the number of results shown on each page of search
+// results.
+const searchPageSize = 10
+
+// Create a new themeForm struct to hold the choice of syntax highlighting
+// theme, along with the page to go back to afterwards.
+type themeForm struct {
+	Theme string `form:"theme"`
+	Next string `form:"next"`
+	validator.Validator `form:"-"`
+}
+
+// Create a new forgotPasswordForm struct.
+type forgotPasswordForm struct {
+	Email string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// Create a new resetPasswordForm struct. The token comes from the link in
+// the password reset email, and is carried through the form in a hidden
+// field.
+type resetPasswordForm struct {
+	Token string `form:"token"`
+	Password string `form:"password"`
+	ConfirmPassword string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
+// passwordResetTTL is how long a password reset link can be used for.
+const passwordResetTTL = time.Hour
+
+// emailVerificationTTL is how long an email verification link can be used
+// for, and verificationResendInterval is how long a user has to wait before
+// asking for another one.
+const (
+	emailVerificationTTL = 3 * 24 * time.Hour
+	verificationResendInterval = 5 * time.Minute
+)
+
+// Create a new accountForm struct for the forms on the account page. Each
+// form only sends some of the fields, and only those are validated.
+type accountForm struct {
+	Name string `form:"name"`
+	Email string `form:"email"`
+	Password string `form:"password"`
+	CurrentPassword string `form:"current_password"`
+	NewPassword string `form:"new_password"`
+	ConfirmPassword string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
+// Create a new twoFactorForm struct to hold a code from an authenticator app,
+// or a recovery code.
+type twoFactorForm struct {
+	Code string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// Create a new passwordConfirmForm struct for actions which need the user to
+// type in their password again, such as turning off two-factor
+// authentication.
+type passwordConfirmForm struct {
+	Password string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorLoginTTL is how long a user has to give their second factor after
+// giving the right password, and maxTwoFactorAttempts is how many wrong codes
+// they can give before they have to start again.
+const (
+	twoFactorLoginTTL = 5 * time.Minute
+	maxTwoFactorAttempts = 5
+)
+
+// Create a new apiTokenForm struct to hold the details of a new API token.
+type apiTokenForm struct {
+	Name string `form:"name"`
+	Scopes []string `form:"scopes"`
+	Expires string `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+// HasScope reports whether a scope has been ticked on the form, so that the
+// checkboxes can be redisplayed as they were.
+func (form apiTokenForm) HasScope(scope string) bool {
+	return validator.PermittedValue(scope, form.Scopes...)
+}
+
+// tokenExpiryDurations maps the expiry options on the API token form to how
+// long the token lasts. The form also accepts "never".
+var tokenExpiryDurations = map[string]time.Duration{
+	"7d": 7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+	"365d": 365 * 24 * time.Hour,
 }
 
 // Create a new userSignupForm struct.
@@ -44,256 +312,1978 @@ type userLoginForm struct {
 	
 	
 
-func (app *application) home(w http.ResponseWriter, r *http.Request) { 
-	snippets, err := app.snippets.Latest() 
+func (app *application) home(w http.ResponseWriter, r *http.Request) { 
+	snippets, err := app.snippets.Latest() 
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Call the newTemplateData() helper to get a templateData struct containing 
+	// the 'default' data (which for now is just the current year), and add the 
+	// snippets slice to it.
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	// Use the new render helper.
+	app.render(w, http.StatusOK, "home.tmpl", data)
+}
+
+
+func (app *application) snippetList(w http.ResponseWriter, r *http.Request) {
+	app.renderSnippetList(w, r, "")
+}
+
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// A tag which could never have been saved can't have any snippets.
+	tag := params.ByName("name")
+	if !validator.Matches(tag, validator.TagRX) {
+		app.notFound(w)
+		return
+	}
+
+	app.renderSnippetList(w, r, tag)
+}
+
+// The renderSnippetList method renders a page of the public snippet listing,
+// limited to the snippets with the given tag unless it is empty. It's shared
+// by the /snippets and /tag/:name pages.
+func (app *application) renderSnippetList(w http.ResponseWriter, r *http.Request, tag string) {
+	// Start with the default options, and then overwrite them with any
+	// values given in the query string.
+	form := newSnippetListForm()
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.validate()
+
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	opts, err := form.options(tag)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.List(opts)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Snippets = page.Snippets
+	data.Tag = tag
+
+	if page.Next != nil {
+		data.NextCursor = page.Next.Encode()
+	}
+	if page.Prev != nil {
+		data.PrevCursor = page.Prev.Encode()
+	}
+
+	app.render(w, http.StatusOK, "snippets.tmpl", data)
+}
+
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	form := searchForm{Page: 1}
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Q, 200), "q", "This field cannot be more than 200 characters long")
+	form.CheckField(form.Page >= 1 && form.Page <= 100, "page", "This field must be between 1 and 100")
+
+	data := app.newTemplateData(r)
+	data.Form = form
+
+	query := models.ParseSearchQuery(form.Q)
+
+	// If there's nothing to search for, just show the search form. The
+	// in-process index has its own idea of what counts as a search term.
+	empty := query.Empty()
+	if app.searchIndex != nil {
+		empty = len(search.Tokenize(form.Q)) == 0
+	}
+
+	if !form.Valid() || empty {
+		app.render(w, http.StatusOK, "search.tmpl", data)
+		return
+	}
+
+	// Fetch one extra result so we know whether there's another page.
+	offset := (form.Page - 1) * searchPageSize
+
+	var snippets []*models.Snippet
+	if app.searchIndex != nil {
+		snippets, err = app.searchWithIndex(r, form.Q, offset, searchPageSize+1)
+	} else {
+		snippets, err = app.snippets.Search(query, app.authenticatedUserID(r), offset, searchPageSize+1)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if len(snippets) > searchPageSize {
+		snippets = snippets[:searchPageSize]
+		data.NextPage = form.Page + 1
+	}
+	if form.Page > 1 {
+		data.PrevPage = form.Page - 1
+	}
+
+	rx := matchRegexp(query)
+	for _, snippet := range snippets {
+		data.SearchResults = append(data.SearchResults, searchResult{
+			Snippet: snippet,
+			Title: markMatches(snippet.Title, rx),
+			Excerpt: excerpt(snippet.Content, rx),
+		})
+	}
+
+	app.render(w, http.StatusOK, "search.tmpl", data)
+}
+
+// The searchWithIndex method runs a search against the in-process index and
+// then fetches the matching snippets from the database, so that the usual
+// expiry, trash and visibility rules are still applied to whatever the index
+// returns. As with SnippetModel.Search, only public snippets without a view
+// limit, or the user's own snippets, are shown. Since some hits can be dropped by those rules, offset and limit
+// count the snippets which are left, and hits are fetched from the index in
+// batches until there are enough of them.
+func (app *application) searchWithIndex(r *http.Request, q string, offset, limit int) ([]*models.Snippet, error) {
+	batchSize := offset + limit
+	snippets := []*models.Snippet{}
+	skipped := 0
+
+	// raw is the position in the index's own results of the next hit to
+	// fetch.
+	raw := 0
+
+	userID := app.authenticatedUserID(r)
+
+	for {
+		results := app.searchIndex.Search(q, userID, raw, batchSize)
+
+		ids := make([]int, len(results))
+		for i, result := range results {
+			ids[i] = result.ID
+		}
+
+		found, err := app.snippets.GetMany(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		// Anything the index returned which is no longer in the database
+		// has expired or been deleted without the index hearing about it,
+		// so take it out of the index now.
+		// That moves the later hits up, so the next batch starts that many
+		// places earlier.
+		live := make(map[int]bool, len(found))
+		for _, s := range found {
+			live[s.ID] = true
+		}
+		for _, id := range ids {
+			if !live[id] {
+				app.searchIndex.Remove(id)
+			}
+		}
+		raw += len(found)
+
+		// The index is only flushed now and then, and isn't shared between
+		// servers, so it can think a snippet is public when it no longer
+		// is. Check again against the database.
+		for _, s := range found {
+			if !(s.Visibility == models.VisibilityPublic && s.MaxViews == 0) && !s.OwnedBy(userID) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+
+			snippets = append(snippets, s)
+			if len(snippets) == limit {
+				return snippets, nil
+			}
+		}
+
+		// A short batch means the index has run out of hits.
+		if len(results) < batchSize {
+			return snippets, nil
+		}
+	}
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) { 
+	// When httprouter is parsing a request, the values of any named parameters 
+	// will be stored in the request context. We'll talk about request context 
+	// in detail later in the book, but for now it's enough to know that you can 
+	// use the ParamsFromContext() function to retrieve a slice containing these 
+	// parameter names and values like so:
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// We can then use the ByName() method to get the value of the "slug" named 
+	// parameter from the slice.
+	slug := params.ByName("slug")
+
+	snippet, err := app.snippets.Get(slug) 
+
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) { 
+			app.redirectLegacySnippet(w, r, slug)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Check that the snippet can be seen, and count the view if needed. This
+	// may be the snippet's last view, in which case it will have been
+	// deleted and the template will warn the viewer.
+	snippet, ok := app.recordView(w, r, snippet)
+	if !ok {
+		return
+	}
+
+	// Use the PopString() method to retrieve the value for the "flash" key. 
+	// PopString() also deletes the key and value from the session data, so it 
+	// acts like a one-time fetch. If there is no matching key in the session 
+	// data this will return the empty string.
+	flash := app.sessionManager.PopString(r.Context(), "flash")	
+
+	// Render the content, either from Markdown or with syntax highlighting.
+	// Both produce safe HTML, so it can be written into the page as it is.
+	var rendered string
+	if snippet.Format == models.FormatMarkdown {
+		rendered, err = markdown.Render(snippet.Content)
+	} else {
+		rendered, err = highlight.Render(snippet.Content, snippet.Language)
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// And do the same thing again here...
+	data := app.newTemplateData(r) 
+	data.Snippet = snippet
+	data.Rendered = template.HTML(rendered)
+
+	// Pass the flash message to the template.
+	data.Flash = flash
+	
+	app.render(w, http.StatusOK, "view.tmpl", data)
+}
+
+// The recordView method is used whenever the content of a snippet is about to
+// be shown. It checks the snippet can be seen and counts the view with
+// countView(), returning the snippet as it was seen. If the snippet can't be
+// shown, a response is sent and ok is false.
+func (app *application) recordView(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) (*models.Snippet, bool) {
+	snippet, err := app.countView(w, r, snippet)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+// The countView method does the work of recordView without sending any
+// error responses. Private snippets are only shown to their owner: for
+// anyone else it returns models.ErrNoRecord rather than a permission error,
+// so as not to reveal that the snippet exists. If the snippet has a view
+// limit and the viewer isn't its owner then the view is counted, and the
+// snippet as it was seen is returned.
+func (app *application) countView(w http.ResponseWriter, r *http.Request, snippet *models.Snippet) (*models.Snippet, error) {
+	if !snippet.VisibleTo(app.authenticatedUserID(r)) {
+		return nil, models.ErrNoRecord
+	}
+
+	if snippet.MaxViews > 0 && !snippet.OwnedBy(app.authenticatedUserID(r)) {
+		snippet, err := app.snippets.RecordView(snippet.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Make sure the response isn't cached anywhere, as the content
+		// should only be seen the permitted number of times.
+		w.Header().Add("Cache-Control", "no-store")
+
+		return snippet, nil
+	}
+
+	return snippet, nil
+}
+
+// The snippetRaw handler serves a snippet's content on its own as plain text,
+// so it can be fetched with curl or opened in an editor.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.rawSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	w.Write([]byte(snippet.Content))
+}
+
+// The snippetDownload handler serves a snippet's content as a file download,
+// named after its title and language.
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.rawSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": snippetFilename(snippet),
+	}))
+
+	w.Write([]byte(snippet.Content))
+}
+
+// The rawSnippet method fetches the snippet named in the URL for the raw and
+// download handlers, and sets the headers they have in common. Raw content
+// is always sent as plain text, and nosniff stops browsers from deciding
+// that it's really HTML or JavaScript.
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	snippet, err := app.snippets.Get(params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	snippet, ok := app.recordView(w, r, snippet)
+	if !ok {
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	return snippet, true
+}
+
+// The redirectLegacySnippet method handles /snippet/view/ URLs which contain
+// a numeric ID rather than a slug, as used before snippets had slugs. Public
+// snippets are permanently redirected to their new URL. Anything else gets a
+// 404, so that counting upwards can't be used to discover unlisted or private
+// snippets.
+func (app *application) redirectLegacySnippet(w http.ResponseWriter, r *http.Request, param string) {
+	id, err := strconv.Atoi(param)
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippet, err := app.snippets.GetByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if snippet.Visibility != models.VisibilityPublic {
+		app.notFound(w)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", snippet.Slug), http.StatusMovedPermanently)
+}
+
+// The canBrowseSnippet method reports whether the current user may see the
+// content of a snippet anywhere other than its view page, such as its history.
+// Snippets with a view limit are only available through the view page (where
+// views are counted), unless the user is their owner.
+func (app *application) canBrowseSnippet(r *http.Request, snippet *models.Snippet) bool {
+	userID := app.authenticatedUserID(r)
+
+	if snippet.MaxViews > 0 {
+		return snippet.OwnedBy(userID)
+	}
+
+	return snippet.VisibleTo(userID)
+}
+
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// Only show the history of snippets which can currently be viewed.
+	snippet, err := app.snippets.Get(params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.canBrowseSnippet(r, snippet) {
+		app.notFound(w)
+		return
+	}
+
+	revisions, err := app.revisions.All(snippet.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+
+	app.render(w, http.StatusOK, "history.tmpl", data)
+}
+
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// The versions to compare are given in the query string, for example
+	// ?from=1&to=3. Both must be present and refer to existing revisions.
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil || from < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil || to < 1 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippet, err := app.snippets.Get(params.ByName("slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !app.canBrowseSnippet(r, snippet) {
+		app.notFound(w)
+		return
+	}
+
+	fromRevision, err := app.revisions.Get(snippet.ID, from)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	toRevision, err := app.revisions.Get(snippet.ID, to)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.FromRevision = fromRevision
+	data.ToRevision = toRevision
+	data.DiffHunks = diff.Unified(diff.Lines(fromRevision.Content, toRevision.Content), 3)
+
+	app.render(w, http.StatusOK, "diff.tmpl", data)
+}
+
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) { 
+	data := app.newTemplateData(r)
+	// Initialize a new createSnippetForm instance and pass it to the template. 
+	// Notice how this is also a great opportunity to set any default or
+	// 'initial' values for the form --- here we set the initial value for the 
+	// snippet expiry to 365 days, make it public and detect its language.
+	data.Form = snippetCreateForm{
+		Language: "auto",
+		Format: models.FormatText,
+		Expires: "365d",
+		Visibility: models.VisibilityPublic,
+	}
+	app.render(w, http.StatusOK, "create.tmpl", data)
+}
+
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) { 
+	// First we call r.ParseForm() which adds any data in POST request bodies
+	// to the r.PostForm map. This also works in the same way for PUT and PATCH
+	// requests. If there are any errors, we use our app.ClientError() helper to
+	// send a 400 Bad Request response to the user.
+	err := r.ParseForm() 
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Declare a new empty instance of the snippetCreateForm struct.
+	var form snippetCreateForm
+
+	// Call the Decode() method of the form decoder, passing in the current
+	// request and *a pointer* to our snippetCreateForm struct. This will
+	// essentially fill our struct with the relevant values from the HTML form. 
+	// If there is a problem, we return a 400 Bad Request response to the client. 
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Then validate and use the data as normal...
+	form.validate()
+
+
+	// Use the Valid() method to see if any of the checks failed. If they did, 
+	// then re-render the template passing in the form in the same way as
+	// before.
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "create.tmpl", data) 
+		return
+	}
+
+	// Record the current user as the owner of the new snippet.
+	slug, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, form.Content, form.language, form.Format, form.expiry, form.Visibility, form.MaxViews, form.tags)
+
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+
+	// Use the Put() method to add a string value ("Snippet successfully
+	// created!") and the corresponding key ("flash") to the session data. 
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+}
+
+// maxPasteBytes is the largest request body accepted by /paste, which is as
+// much as will fit in the snippets.content column.
+const maxPasteBytes = 65535
+
+// The snippetPaste handler creates a snippet from the raw request body, so
+// that snippets can be created from the terminal with:
+//
+//	curl --data-binary @file https://host/paste?title=...
+//
+// The other snippet options are taken from the query string, using the same
+// names and validation as the create form. The body is never parsed as a
+// form, whatever Content-Type curl sends with it. On success the snippet's
+// URL is returned as plain text.
+func (app *application) snippetPaste(w http.ResponseWriter, r *http.Request) {
+	// Pastes are unlisted by default, since they're often just a way of
+	// getting some output from one machine to another.
+	form := snippetCreateForm{
+		Title: "Untitled",
+		Language: "auto",
+		Format: models.FormatText,
+		Expires: "365d",
+		Visibility: models.VisibilityUnlisted,
+	}
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasteBytes)
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.clientError(w, http.StatusRequestEntityTooLarge)
+		} else {
+			app.clientError(w, http.StatusBadRequest)
+		}
+		return
+	}
+
+	form.Content = string(content)
+	form.validate()
+	form.CheckField(utf8.Valid(content), "content", "This field must be UTF-8 text")
+
+	unverified, err := app.needsVerification(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if unverified {
+		http.Error(w, "You must verify your email address before creating snippets", http.StatusForbidden)
+		return
+	}
+
+	// Nobody would be able to see a private snippet without an owner.
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		form.CheckField(form.Visibility != models.VisibilityPrivate, "visibility", "Anonymous snippets cannot be private")
+	}
+
+	if !form.Valid() {
+		app.validationErrorText(w, form.Validator)
+		return
+	}
+
+	slug, err := app.snippets.Insert(userID, form.Title, form.Content, form.language, form.Format, form.expiry, form.Visibility, form.MaxViews, form.tags)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	url := fmt.Sprintf("%s/snippet/view/%s", app.baseURL, slug)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
+
+// newSnippetEditForm returns a snippetCreateForm pre-filled with the current
+// state of a snippet. The expiry is given as a custom time, so that it stays
+// the same unless the user changes it.
+func newSnippetEditForm(snippet *models.Snippet) snippetCreateForm {
+	form := snippetCreateForm{
+		Title: snippet.Title,
+		Content: snippet.Content,
+		Language: snippet.Language,
+		Format: snippet.Format,
+		Expires: "keep",
+		Visibility: snippet.Visibility,
+		BurnAfterReading: snippet.MaxViews == 1,
+		MaxViews: snippet.MaxViews,
+		Tags: strings.Join(snippet.Tags, ", "),
+		currentExpiry: &snippet.Expires,
+	}
+	// The custom expiry time is filled in as a starting point, in case the
+	// user wants to change it.
+	if !snippet.Expires.IsZero() {
+		form.ExpiresAt = snippet.Expires.UTC().Format(expiresAtLayout)
+	}
+
+	return form
+}
+
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	// The requireSnippetOwner middleware has already loaded the snippet and
+	// checked that it belongs to the current user.
+	snippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = newSnippetEditForm(snippet)
+	app.render(w, http.StatusOK, "edit.tmpl", data)
+}
+
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	snippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+
+	form := snippetCreateForm{currentExpiry: &snippet.Expires}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.validate()
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "edit.tmpl", data)
+		return
+	}
+
+	err = app.snippets.Update(snippet.ID, app.authenticatedUserID(r), form.Title, form.Content, form.language, form.Format, form.expiry, form.Visibility, form.MaxViews, form.tags)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", snippet.Slug), http.StatusSeeOther)
+}
+
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	snippet := r.Context().Value(snippetContextKey).(*models.Snippet)
+
+	err := app.snippets.Delete(snippet.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet moved to the trash.")
+
+	http.Redirect(w, r, "/user/trash", http.StatusSeeOther)
+}
+
+func (app *application) userTrash(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.Trash(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	app.render(w, http.StatusOK, "trash.tmpl", data)
+}
+
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	slug := params.ByName("slug")
+
+	// The Restore() method only matches snippets in the current user's
+	// trash, so there's no need for a separate ownership check here.
+	err := app.snippets.Restore(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet restored.")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+}
+
+func (app *application) snippetPurgePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	err := app.snippets.Purge(params.ByName("slug"), app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet permanently deleted.")
+
+	http.Redirect(w, r, "/user/trash", http.StatusSeeOther)
+}
+
+func (app *application) userTokens(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = apiTokenForm{
+		Scopes: []string{models.ScopeSnippetsRead},
+		Expires: "30d",
+	}
+
+	app.renderTokens(w, r, http.StatusOK, data)
+}
+
+func (app *application) userTokensPost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(len(form.Scopes) > 0, "scopes", "Choose at least one scope")
+	for _, scope := range form.Scopes {
+		form.CheckField(validator.PermittedValue(scope, models.Scopes...), "scopes", "Scopes must be chosen from the list")
+	}
+
+	var expires time.Time
+	if form.Expires != "never" {
+		duration, ok := tokenExpiryDurations[form.Expires]
+		form.CheckField(ok, "expires", "This field must be one of the listed options")
+		expires = time.Now().UTC().Add(duration)
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.renderTokens(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+
+	token, err := app.apiTokens.Insert(app.authenticatedUserID(r), form.Name, form.Scopes, expires)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The token is kept in the session just long enough to be shown once on
+	// the tokens page, in the same way as a flash message.
+	app.sessionManager.Put(r.Context(), "apiToken", token)
+	app.sessionManager.Put(r.Context(), "flash", "Token created. Copy it now, as you won't be able to see it again!")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+// The renderTokens method renders the API tokens page with the user's
+// current tokens, and the token that has just been created if there is one.
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, status int, data *templateData) {
+	tokens, err := app.apiTokens.All(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data.APITokens = tokens
+	data.NewAPIToken = app.sessionManager.PopString(r.Context(), "apiToken")
+
+	app.render(w, status, "tokens.tmpl", data)
+}
+
+func (app *application) userTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// Revoke() only matches the current user's tokens, so there's no need
+	// for a separate ownership check here.
+	err = app.apiTokens.Revoke(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Token revoked.")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) { 
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, http.StatusOK, "signup.tmpl", data)
+}
+
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) { 
+	// Declare an zero-valued instance of our userSignupForm struct.
+	var form userSignupForm
+
+	// Parse the form data into the userSignupForm struct.
+	err := app.decodePostForm(r, &form) 
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the form contents using our helper functions.
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank") 
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address") 
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank") 
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	
+	// If there are any errors, redisplay the signup form along with a 422 
+	// status code.
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data) 
+		return
+	}
+
+	// Try to create a new user record in the database. If the email already 
+	// exists then add an error message to the form and re-display it.
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) { 
+			form.AddFieldError("email", "Email address is already in use")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		} else {
+			app.serverError(w, err)
+		}
+
+		return
+	}
+
+	// Send the new user a link to verify their email address.
+	err = app.sendVerificationEmail(id, form.Name, form.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Otherwise add a confirmation flash message to the session confirming that
+	// their signup worked.
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. We've sent you an email to verify your address. Please log in.")
+	
+	// And redirect the user to the login page.
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) { 
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, http.StatusOK, "login.tmpl", data)
+}
+
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) { 
+	// Decode the form data into the userLoginForm struct.
+	var form userLoginForm
+
+	err := app.decodePostForm(r, &form) 
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Do some validation checks on the form. We check that both email and
+	// password are provided, and also check the format of the email address as
+	// a UX-nicety (in case the user makes a typo).
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank") 
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address") 
+	form.CheckField(validator.MaxChars(form.Email, 255), "email", "This field cannot be more than 255 characters long")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data) 
+		return
+	}
+
+	// Refuse to even check the password if there have been too many failed
+	// logins for this account or from this IP address recently.
+	attempt, err := app.startLogin(r, form.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if attempt.wait > 0 {
+		err = app.loginAudit.Insert(form.Email, attempt.ip, r.UserAgent(), models.LoginFailureThrottled)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.AddNonFieldError(tooManyLoginsMessage(attempt.wait))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login.tmpl", data)
+		return
+	}
+
+	// Check whether the credentials are valid. If they're not, add a generic 
+	// non-field error message and re-display the login page.
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) { 
+			err = app.loginFailed(r, attempt, models.LoginFailurePassword)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+
+			form.AddNonFieldError("Email or password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The password was right, so take back the attempt. If the user has
+	// turned on two-factor authentication the account keeps its earlier
+	// failures until they've given a code as well, so that guessing codes
+	// is limited too. Otherwise, start counting from scratch.
+	err = app.loginSucceeded(attempt, !user.TwoFactorEnabled)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// If the user has turned on two-factor authentication, the password
+	// isn't enough to log in. Remember who they are for a few minutes, and
+	// ask for a code.
+	if user.TwoFactorEnabled {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+		app.sessionManager.Put(r.Context(), "twoFactorExpires", time.Now().Add(twoFactorLoginTTL))
+		app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Log the user in to the current session.
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	
+	// Redirect the user to the create snippet page.
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// The sendVerificationEmail method issues a new email verification token for
+// a user and emails them a link containing it.
+func (app *application) sendVerificationEmail(id int, name, email string) error {
+	token, err := app.emailTokens.New(id, models.PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return app.sendEmail(email, "verify_email.tmpl", map[string]any{
+		"Name": name,
+		"URL": fmt.Sprintf("%s/user/verify?token=%s", app.baseURL, token),
+		"Expiry": "3 days",
+	})
+}
+
+// The userVerifyEmail handler is the target of the link in the verification
+// email. The token in the link is all that's needed, so the user doesn't
+// have to be logged in.
+func (app *application) userVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.emailTokens.Consume(r.URL.Query().Get("token"), models.PurposeEmailVerification)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorPage(w, r, http.StatusBadRequest, "This verification link is invalid or has expired. Log in to ask for a new one.")
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.users.SetEmailVerified(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.emailTokens.DeleteAll(userID, models.PurposeEmailVerification)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks, your email address has been verified.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// The userVerifyEmailResendPost handler sends the current user another
+// verification email. Users can only ask for one every few minutes, so the
+// button can't be used to flood someone's inbox.
+func (app *application) userVerifyEmailResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if user.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address has already been verified.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	last, err := app.emailTokens.LastIssued(user.ID, models.PurposeEmailVerification)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if time.Since(last) < verificationResendInterval {
+		app.sessionManager.Put(r.Context(), "flash", "We've sent you a verification email recently. Please wait a few minutes before asking for another.")
+		http.Redirect(w, r, "/user/verify/resend", http.StatusSeeOther)
+		return
+	}
+
+	err = app.sendVerificationEmail(user.ID, user.Name, user.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("We've sent a new verification link to %s.", user.Email))
+
+	http.Redirect(w, r, "/user/verify/resend", http.StatusSeeOther)
+}
+
+// The userVerifyEmailResend handler shows the page explaining that the
+// user's email address needs to be verified, with a button to send another
+// link.
+func (app *application) userVerifyEmailResend(w http.ResponseWriter, r *http.Request) {
+	app.renderUnverified(w, r, http.StatusOK)
+}
+
+// The renderUnverified method renders the page explaining that the current
+// user needs to verify their email address.
+func (app *application) renderUnverified(w http.ResponseWriter, r *http.Request, status int) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+
+	app.render(w, status, "unverified.tmpl", data)
+}
+
+func (app *application) userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = forgotPasswordForm{}
+	app.render(w, http.StatusOK, "forgot_password.tmpl", data)
+}
+
+func (app *application) userForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form forgotPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "forgot_password.tmpl", data)
+		return
+	}
+
+	// Only send an email if there's an account with this address, but show
+	// the same message either way so that the form can't be used to find
+	// out who has an account.
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	if user != nil {
+		token, err := app.emailTokens.New(user.ID, models.PurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.sendEmail(user.Email, "password_reset.tmpl", map[string]any{
+			"Name": user.Name,
+			"URL": fmt.Sprintf("%s/user/password/reset?token=%s", app.baseURL, token),
+			"Expiry": "1 hour",
+		})
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If there's an account with that email address, we've sent it a link to reset the password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = resetPasswordForm{Token: r.URL.Query().Get("token")}
+	app.render(w, http.StatusOK, "reset_password.tmpl", data)
+}
+
+func (app *application) userResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form resetPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(form.ConfirmPassword == form.Password, "confirm_password", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "reset_password.tmpl", data)
+		return
+	}
+
+	// The token is used up here, so the same link can't be used twice.
+	userID, err := app.emailTokens.Consume(form.Token, models.PurposePasswordReset)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			form.AddNonFieldError("This password reset link is invalid or has expired. Please ask for a new one.")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, http.StatusUnprocessableEntity, "reset_password.tmpl", data)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Changing the password logs out all of the user's existing sessions.
+	err = app.users.UpdatePassword(userID, form.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Any other reset links the user has been sent shouldn't work any more.
+	err = app.emailTokens.DeleteAll(userID, models.PurposePasswordReset)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// The account handler shows the details of the current user, with forms for
+// changing them.
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.renderAccount(w, r, http.StatusOK, user, accountForm{Name: user.Name, Email: user.Email})
+}
+
+// The renderAccount method renders the account page with the given form,
+// which holds the values and errors for all of the forms on the page.
+func (app *application) renderAccount(w http.ResponseWriter, r *http.Request, status int, user *models.User, form accountForm) {
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	app.render(w, status, "account.tmpl", data)
+}
+
+// The decodeAccountForm method fetches the current user and decodes an
+// accountForm over the top of their details, so that the other forms on the
+// page keep their values if the page has to be shown again.
+func (app *application) decodeAccountForm(w http.ResponseWriter, r *http.Request) (*models.User, *accountForm, bool) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return nil, nil, false
+	}
+
+	form := &accountForm{Name: user.Name, Email: user.Email}
+
+	err = app.decodePostForm(r, form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	return user, form, true
+}
+
+func (app *application) accountNamePost(w http.ResponseWriter, r *http.Request) {
+	user, form, ok := app.decodeAccountForm(w, r)
+	if !ok {
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "This field cannot be more than 255 characters long")
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, user, *form)
+		return
+	}
+
+	err := app.users.UpdateName(user.ID, form.Name)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your name has been changed.")
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// The checkAccountPassword method checks that password is the current
+// user's password, adding an error to the form under key if it isn't.
+func (app *application) checkAccountPassword(user *models.User, form *accountForm, key, password string) error {
+	if !validator.NotBlank(password) {
+		form.AddFieldError(key, "This field cannot be blank")
+		return nil
+	}
+
+	_, err := app.users.Authenticate(user.Email, password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		form.AddFieldError(key, "Your password is incorrect")
+		return nil
+	}
+
+	return err
+}
+
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	user, form, ok := app.decodeAccountForm(w, r)
+	if !ok {
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(form.Email != user.Email, "email", "This is already your email address")
+
+	// Since the email address can be used to reset the password, changing
+	// it needs the password too.
+	err := app.checkAccountPassword(user, form, "password", form.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, user, *form)
+		return
+	}
+
+	err = app.users.UpdateEmail(user.ID, form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email address is already in use")
+			app.renderAccount(w, r, http.StatusUnprocessableEntity, user, *form)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Links sent to the old address shouldn't work any more.
+	for _, purpose := range []string{models.PurposePasswordReset, models.PurposeEmailVerification} {
+		err = app.emailTokens.DeleteAll(user.ID, purpose)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err = app.sendVerificationEmail(user.ID, user.Name, form.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed. We've sent you an email to verify the new address.")
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	user, form, ok := app.decodeAccountForm(w, r)
+	if !ok {
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "new_password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password", "This field must be at least 8 characters long")
+	form.CheckField(form.ConfirmPassword == form.NewPassword, "confirm_password", "Passwords do not match")
+
+	err := app.checkAccountPassword(user, form, "current_password", form.CurrentPassword)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if !form.Valid() {
+		app.renderAccount(w, r, http.StatusUnprocessableEntity, user, *form)
+		return
+	}
+
+	// Changing the password logs out all of the user's sessions, so log
+	// this one in again straight away. This renews the session token too.
+	err = app.users.UpdatePassword(user.ID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.logIn(r, user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed. Any other devices have been logged out.")
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountLimitKey returns the key used by the login limiter for the account
+// with the given email address. Email addresses are compared without regard
+// to case, so that changing the case doesn't give an attacker more guesses.
+func accountLimitKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// A loginAttempt is a login which has been counted by the login limiters,
+// before its password or code is checked.
+type loginAttempt struct {
+	email string
+	ip    string
+	// wait is how long the client has to wait before trying again. If it's
+	// more than 0 the attempt was refused, and nothing was counted.
+	wait time.Duration
+	// locked reports whether the account is locked out if the attempt
+	// fails.
+	locked bool
+}
+
+// The startLogin method counts an attempt to log in to the account with the
+// given email address, before the password or code is checked, so that
+// guesses made in parallel can't all get past the limits at once. Attempts
+// are counted both for the account, whether or not it exists, and for the
+// client's IP address, which may be guessing at many accounts. Each attempt
+// which is let through must be finished with loginFailed or loginSucceeded.
+func (app *application) startLogin(r *http.Request, email string) (loginAttempt, error) {
+	attempt := loginAttempt{email: email, ip: clientIP(r)}
+
+	ip, err := app.ipLimiter.Attempt("ip:" + attempt.ip)
+	if err != nil || ip.Wait > 0 {
+		attempt.wait = ip.Wait
+		return attempt, err
+	}
+
+	account, err := app.accountLimiter.Attempt(accountLimitKey(email))
+	if err != nil || account.Wait > 0 {
+		// Don't count the attempt against the IP address if it wasn't
+		// made.
+		if err := app.ipLimiter.Release("ip:" + attempt.ip); err != nil {
+			return attempt, err
+		}
+
+		attempt.wait = account.Wait
+		return attempt, err
+	}
+
+	attempt.locked = account.Locked
+	return attempt, nil
+}
+
+// The loginFailed method records a failed login in the audit log. The
+// attempt has already been counted by startLogin, but if it locks the
+// account, its owner is sent an email about it.
+func (app *application) loginFailed(r *http.Request, attempt loginAttempt, reason string) error {
+	err := app.loginAudit.Insert(attempt.email, attempt.ip, r.UserAgent(), reason)
+	if err != nil || !attempt.locked {
+		return err
+	}
+
+	user, err := app.users.GetByEmail(attempt.email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return nil
+		}
+		return err
+	}
+
+	return app.sendEmail(user.Email, "account_locked.tmpl", map[string]any{
+		"Name": user.Name,
+		"IP": attempt.ip,
+		"Lockout": humanWait(app.accountLimiter.Policy.Lockout),
+		"URL": fmt.Sprintf("%s/user/password/forgot", app.baseURL),
+	})
+}
+
+// The loginSucceeded method takes back an attempt counted by startLogin,
+// once the password or code was right. If done is true the user is now
+// logged in, and all of the failures for the account are forgotten.
+func (app *application) loginSucceeded(attempt loginAttempt, done bool) error {
+	err := app.ipLimiter.Release("ip:" + attempt.ip)
+	if err != nil {
+		return err
+	}
+
+	if done {
+		return app.accountLimiter.Reset(accountLimitKey(attempt.email))
+	}
+
+	return app.accountLimiter.Release(accountLimitKey(attempt.email))
+}
+
+// tooManyLoginsMessage returns the error shown when a login is refused
+// because of earlier failures.
+func tooManyLoginsMessage(wait time.Duration) string {
+	return fmt.Sprintf("Too many failed login attempts. Please try again in %s.", humanWait(wait))
+}
+
+// The pendingTwoFactorUserID method returns the ID of the user who has given
+// the right password in this session but still needs to give their second
+// factor, or 0 if there isn't one.
+func (app *application) pendingTwoFactorUserID(r *http.Request) int {
+	if time.Now().After(app.sessionManager.GetTime(r.Context(), "twoFactorExpires")) {
+		return 0
+	}
+
+	return app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+}
+
+// The clearPendingTwoFactor method forgets about a login which is waiting for
+// a second factor.
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpires")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, http.StatusOK, "login_2fa.tmpl", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUserID(r)
+	if id == 0 {
+		app.clearPendingTwoFactor(r)
+		app.sessionManager.Put(r.Context(), "flash", "Your login has timed out. Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Wrong codes count as failed logins for the account, so the same
+	// limits apply as for passwords.
+	attempt, err := app.startLogin(r, user.Email)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Call the newTemplateData() helper to get a templateData struct containing 
-	// the 'default' data (which for now is just the current year), and add the 
-	// snippets slice to it.
-	data := app.newTemplateData(r)
-	data.Snippets = snippets
+	if attempt.wait > 0 {
+		err = app.loginAudit.Insert(user.Email, attempt.ip, r.UserAgent(), models.LoginFailureThrottled)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 
-	// Use the new render helper.
-	app.render(w, http.StatusOK, "home.tmpl", data)
-}
+		form.AddNonFieldError(tooManyLoginsMessage(attempt.wait))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusTooManyRequests, "login_2fa.tmpl", data)
+		return
+	}
 
+	recovery, ok, err := app.checkSecondFactor(id, form.Code)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) { 
-	// When httprouter is parsing a request, the values of any named parameters 
-	// will be stored in the request context. We'll talk about request context 
-	// in detail later in the book, but for now it's enough to know that you can 
-	// use the ParamsFromContext() function to retrieve a slice containing these 
-	// parameter names and values like so:
-	params := httprouter.ParamsFromContext(r.Context())
+	if !ok {
+		err = app.loginFailed(r, attempt, models.LoginFailureCode)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 
-	// We can then use the ByName() method to get the value of the "id" named 
-	// parameter from the slice and validate it as normal.
-	id, err := strconv.Atoi(params.ByName("id"))
-	if err != nil || id < 1 {
-		app.notFound(w)
+		// Only allow a few guesses before making the user give their
+		// password again.
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		form.AddNonFieldError("That code is incorrect")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, http.StatusUnprocessableEntity, "login_2fa.tmpl", data)
 		return
 	}
 
+	app.clearPendingTwoFactor(r)
 
-	snippet, err := app.snippets.Get(id) 
+	err = app.loginSucceeded(attempt, true)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
+	err = app.logIn(r, id)
 	if err != nil {
-		if errors.Is(err, models.ErrNoRecord) { 
-			app.notFound(w)
-		} else {
+		app.serverError(w, err)
+		return
+	}
+
+	if recovery {
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
 			app.serverError(w, err)
+			return
 		}
-		return
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You used a recovery code, and have %d left.", left))
 	}
 
-	// Use the PopString() method to retrieve the value for the "flash" key. 
-	// PopString() also deletes the key and value from the session data, so it 
-	// acts like a one-time fetch. If there is no matching key in the session 
-	// data this will return the empty string.
-	flash := app.sessionManager.PopString(r.Context(), "flash")	
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
 
-	// And do the same thing again here...
-	data := app.newTemplateData(r) 
-	data.Snippet = snippet
+// The checkSecondFactor method checks a code given by a user with two-factor
+// authentication turned on. Codes from their authenticator app are tried
+// first, and anything else is tried as a recovery code. Either way the code
+// is used up, so it can't be given again. recovery reports whether a
+// recovery code was used.
+func (app *application) checkSecondFactor(userID int, code string) (recovery bool, ok bool, err error) {
+	secret, lastCounter, err := app.twoFactor.Secret(userID)
+	if err != nil {
+		return false, false, err
+	}
 
-	// Pass the flash message to the template.
-	data.Flash = flash
-	
-	app.render(w, http.StatusOK, "view.tmpl", data)
+	if secret == "" {
+		return false, false, nil
+	}
+
+	if counter, valid := totp.Validate(secret, code, time.Now(), lastCounter); valid {
+		err = app.twoFactor.UseCounter(userID, counter)
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, false, nil
+		}
+		return false, err == nil, err
+	}
+
+	err = app.twoFactor.UseRecoveryCode(userID, code)
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, false, nil
+	}
+	return true, err == nil, err
 }
 
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) { 
+// The userTwoFactor handler shows the two-factor authentication settings. If
+// two-factor authentication is off, a new secret is generated and kept in the
+// session until the user confirms that their authenticator app works.
+func (app *application) userTwoFactor(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	// Initialize a new createSnippetForm instance and pass it to the template. 
-	// Notice how this is also a great opportunity to set any default or
-	// 'initial' values for the form --- here we set the initial value for the 
-	// snippet expiry to 365 days.
-	data.Form = snippetCreateForm{ Expires: 365,}
-	app.render(w, http.StatusOK, "create.tmpl", data)
+	data.Form = twoFactorForm{}
+
+	app.renderTwoFactor(w, r, http.StatusOK, data)
 }
 
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) { 
-	// First we call r.ParseForm() which adds any data in POST request bodies
-	// to the r.PostForm map. This also works in the same way for PUT and PATCH
-	// requests. If there are any errors, we use our app.ClientError() helper to
-	// send a 400 Bad Request response to the user.
-	err := r.ParseForm() 
+// The renderTwoFactor method renders the two-factor authentication settings
+// page.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, data *templateData) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.serverError(w, err)
 		return
 	}
 
-	// Declare a new empty instance of the snippetCreateForm struct.
-	var form snippetCreateForm
+	data.User = user
 
-	// Call the Decode() method of the form decoder, passing in the current
-	// request and *a pointer* to our snippetCreateForm struct. This will
-	// essentially fill our struct with the relevant values from the HTML form. 
-	// If there is a problem, we return a 400 Bad Request response to the client. 
-	err = app.decodePostForm(r, &form)
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
-		return
+	if user.TwoFactorEnabled {
+		data.RecoveryCodesLeft, err = app.twoFactor.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	} else {
+		secret := app.sessionManager.GetString(r.Context(), "totpSecret")
+		if secret == "" {
+			secret, err = totp.NewSecret()
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.sessionManager.Put(r.Context(), "totpSecret", secret)
+		}
+
+		data.TOTPSecret = secret
+		data.TOTPURI = totp.URI(totpIssuer, user.Email, secret)
 	}
 
-	// Then validate and use the data as normal...
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank") 
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long") 
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank") 
-	form.CheckField(validator.PermittedInt(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")	
+	app.render(w, status, "two_factor.tmpl", data)
+}
 
+// totpIssuer is the name shown next to the account in authenticator apps.
+const totpIssuer = "Snippetbox"
 
-	// Use the Valid() method to see if any of the checks failed. If they did, 
-	// then re-render the template passing in the form in the same way as
-	// before.
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl", data) 
+// The userTwoFactorQR handler serves the QR code of the provisioning URI for
+// the secret being set up, for authenticator apps to scan.
+func (app *application) userTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpSecret")
+	if secret == "" {
+		app.notFound(w)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
-
+	user, err := app.users.Get(app.authenticatedUserID(r))
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	code, err := qr.Encode(totp.URI(totpIssuer, user.Email, secret), qr.M)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	code.Scale = 6
 
-	// Use the Put() method to add a string value ("Snippet successfully
-	// created!") and the corresponding key ("flash") to the session data. 
-	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
-
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(code.PNG())
 }
 
+func (app *application) userTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "totpSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
 
+	var form twoFactorForm
 
-
-
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) { 
-	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl", data)
-}
-
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) { 
-	// Declare an zero-valued instance of our userSignupForm struct.
-	var form userSignupForm
-
-	// Parse the form data into the userSignupForm struct.
-	err := app.decodePostForm(r, &form) 
+	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// Validate the form contents using our helper functions.
-	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank") 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address") 
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank") 
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
-	
-	// If there are any errors, redisplay the signup form along with a 422 
-	// status code.
+	// Check a code from the app before turning two-factor authentication
+	// on, so that users can't lock themselves out with a bad setup.
+	counter, ok := totp.Validate(secret, form.Code, time.Now(), 0)
+	form.CheckField(ok, "code", "That code is incorrect. Check your device's clock is right and try again")
+
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data) 
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, data)
 		return
 	}
 
-	// Try to create a new user record in the database. If the email already 
-	// exists then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	codes, err := app.twoFactor.Enable(app.authenticatedUserID(r), secret, counter)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) { 
-			form.AddFieldError("email", "Email address is already in use")
+		app.serverError(w, err)
+		return
+	}
 
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl", data)
-		} else {
-			app.serverError(w, err)
-		}
+	app.sessionManager.Remove(r.Context(), "totpSecret")
 
+	// The session has more privileges now, so give it a new ID.
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
-	// Otherwise add a confirmation flash message to the session confirming that
-	// their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
-	
-	// And redirect the user to the login page.
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) { 
+	// The recovery codes are shown straight away rather than after a
+	// redirect, since they can't be shown again.
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl", data)
+	data.Flash = "Two-factor authentication is now on."
+	data.RecoveryCodes = codes
+	app.render(w, http.StatusOK, "recovery_codes.tmpl", data)
 }
 
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) { 
-	// Decode the form data into the userLoginForm struct.
-	var form userLoginForm
+// The confirmPassword method decodes a passwordConfirmForm and checks the
+// password belongs to the current user. If it doesn't, the two-factor
+// settings page is shown again with an error and ok is false.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request) (ok bool) {
+	var form passwordConfirmForm
 
-	err := app.decodePostForm(r, &form) 
+	err := app.decodePostForm(r, &form)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
-		return
+		return false
 	}
 
-	// Do some validation checks on the form. We check that both email and
-	// password are provided, and also check the format of the email address as
-	// a UX-nicety (in case the user makes a typo).
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank") 
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address") 
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data) 
-		return
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return false
 	}
 
-	// Check whether the credentials are valid. If they're not, add a generic 
-	// non-field error message and re-display the login page.
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	_, err = app.users.Authenticate(user.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) { 
-			form.AddNonFieldError("Email or password is incorrect")
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "Your password is incorrect")
+
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl", data)
+			app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, data)
 		} else {
 			app.serverError(w, err)
 		}
+		return false
+	}
+
+	return true
+}
+
+func (app *application) userTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	if app.requireTwoFactorAll {
+		app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is required for all accounts, so it can't be turned off.")
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
 		return
 	}
 
-	// Use the RenewToken() method on the current session to change the session 
-	// ID. It's good practice to generate a new session ID when the
-	// authentication state or privilege levels changes for the user (e.g. login 
-	// and logout operations).
-	err = app.sessionManager.RenewToken(r.Context()) 
+	if !app.confirmPassword(w, r) {
+		return
+	}
+
+	err := app.twoFactor.Disable(app.authenticatedUserID(r))
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Add the ID of the current user to the session, so that they are now 
-	// 'logged in'.
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	
-	// Redirect the user to the create snippet page.
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication is now off.")
+
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+func (app *application) userRecoveryCodesPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Recovery codes are only any use with two-factor authentication on.
+	if !user.TwoFactorEnabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	if !app.confirmPassword(w, r) {
+		return
+	}
+
+	codes, err := app.twoFactor.RegenerateRecoveryCodes(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Flash = "Your old recovery codes no longer work."
+	data.RecoveryCodes = codes
+	app.render(w, http.StatusOK, "recovery_codes.tmpl", data)
 }
 
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) { 
@@ -307,6 +2297,7 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	// Remove the authenticatedUserID from the session data so that the user is 
 	// 'logged out'.
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "sessionVersion")
 
 	// Add a flash message to the session to confirm to the user that they've been
 	// logged out.
@@ -314,4 +2305,53 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 	
 	// Redirect the user to the application home page.
 	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
\ No newline at end of file
+}
+// The themeCSS handler serves the stylesheet for a syntax highlighting theme.
+// The stylesheets never change while the application is running, so browsers
+// are allowed to cache them.
+func (app *application) themeCSS(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	file := params.ByName("file")
+	name := strings.TrimSuffix(file, ".css")
+	if name == file || !highlight.ValidTheme(name) {
+		app.notFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+
+	err := highlight.WriteCSS(w, name)
+	if err != nil {
+		app.serverError(w, err)
+	}
+}
+
+// The themePost handler stores the chosen syntax highlighting theme in the
+// session, so that it's used on every page for the rest of the session.
+func (app *application) themePost(w http.ResponseWriter, r *http.Request) {
+	var form themeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !highlight.ValidTheme(form.Theme) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "theme", form.Theme)
+
+	// Only redirect to paths on this site, so the form can't be used to send
+	// people elsewhere.
+	next := form.Next
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
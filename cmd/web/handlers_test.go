@@ -0,0 +1,179 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/search"
+)
+
+// hostilePayloads are values which would run script in the page if they were
+// written into it without escaping. Each is paired with fragments which must
+// never appear in the response exactly as they were given.
+var hostilePayloads = []struct {
+	name      string
+	payload   string
+	forbidden []string
+}{
+	{
+		name:      "Script tag",
+		payload:   "<script>alert(1)</script>",
+		forbidden: []string{"<script>alert(1)"},
+	},
+	{
+		name:      "Attribute breakout",
+		payload:   `"><img src=x onerror=alert(1)>`,
+		forbidden: []string{"<img src=x", `"><img`},
+	},
+	{
+		name:      "Single quoted attribute breakout",
+		payload:   `'><svg onload=alert(1)>`,
+		forbidden: []string{"<svg onload", `'><svg`},
+	},
+	{
+		name:      "Closing tags",
+		payload:   "</title></textarea><script>alert(1)</script>",
+		forbidden: []string{"</textarea><script>", "<script>alert(1)"},
+	},
+	{
+		name:      "Markdown link",
+		payload:   "[click me](javascript:alert(1))",
+		forbidden: []string{`href="javascript:`, "href='javascript:"},
+	},
+}
+
+// assertEscaped fails the test if any of the forbidden fragments appear in
+// body, and checks that want appears escaped.
+func assertEscaped(t *testing.T, page, body string, forbidden []string, want string) {
+	t.Helper()
+
+	for _, f := range forbidden {
+		if strings.Contains(strings.ToLower(body), strings.ToLower(f)) {
+			t.Errorf("%s: found unescaped %q", page, f)
+		}
+	}
+
+	if want != "" && !strings.Contains(body, template.HTMLEscapeString(want)) {
+		t.Errorf("%s: want body to contain escaped %q", page, want)
+	}
+}
+
+func TestSnippetCreatePostEscapesPayloads(t *testing.T) {
+	for _, format := range []string{models.FormatText, models.FormatMarkdown} {
+		for _, tt := range hostilePayloads {
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				app := newTestApplication(t)
+				ts := newTestServer(t, app)
+
+				code, header, _ := ts.postForm(t, "/snippet/create", url.Values{
+					"title":      {tt.payload},
+					"content":    {tt.payload},
+					"language":   {"plaintext"},
+					"format":     {format},
+					"expires":    {"1d"},
+					"visibility": {models.VisibilityPublic},
+					"tags":       {"go"},
+				})
+
+				if code != http.StatusSeeOther {
+					t.Fatalf("create: got status %d; want %d", code, http.StatusSeeOther)
+				}
+
+				location := header.Get("Location")
+				if !strings.HasPrefix(location, "/snippet/view/") {
+					t.Fatalf("create: got Location %q", location)
+				}
+
+				code, _, body := ts.get(t, location)
+				if code != http.StatusOK {
+					t.Fatalf("view: got status %d; want %d", code, http.StatusOK)
+				}
+				assertEscaped(t, "view", body, tt.forbidden, tt.payload)
+
+				code, _, body = ts.get(t, "/")
+				if code != http.StatusOK {
+					t.Fatalf("home: got status %d; want %d", code, http.StatusOK)
+				}
+				assertEscaped(t, "home", body, tt.forbidden, tt.payload)
+			})
+		}
+	}
+}
+
+// Tags can't contain markup, so hostile tags are rejected, but the form is
+// shown again with the values that were given and those must be escaped too.
+func TestSnippetCreatePostEscapesRejectedTags(t *testing.T) {
+	for _, tt := range hostilePayloads {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app)
+
+			code, _, body := ts.postForm(t, "/snippet/create", url.Values{
+				"title":      {tt.payload},
+				"content":    {tt.payload},
+				"language":   {"plaintext"},
+				"format":     {models.FormatText},
+				"expires":    {"1d"},
+				"visibility": {models.VisibilityPublic},
+				"tags":       {tt.payload},
+			})
+
+			if code != http.StatusUnprocessableEntity {
+				t.Fatalf("got status %d; want %d", code, http.StatusUnprocessableEntity)
+			}
+			assertEscaped(t, "create", body, tt.forbidden, "")
+
+			// Nothing should have been saved, so the home page is empty.
+			_, _, body = ts.get(t, "/")
+			if strings.Contains(body, "/snippet/view/") {
+				t.Error("home: a snippet with invalid tags was saved")
+			}
+		})
+	}
+}
+
+func TestSearchWithIndexRechecksVisibility(t *testing.T) {
+	app := newTestApplication(t)
+
+	// The index still thinks snippets 2 and 3 are public, as it would if
+	// they'd been changed on another server, or before a crash lost the
+	// changes to the index.
+	app.snippets.(*stubSnippetModel).snippets = []*models.Snippet{
+		{ID: 1, Slug: "public", Title: "Kumquat public", Visibility: models.VisibilityPublic, UserID: 2},
+		{ID: 2, Slug: "unlisted", Title: "Kumquat unlisted", Visibility: models.VisibilityUnlisted, UserID: 2},
+		{ID: 3, Slug: "limited", Title: "Kumquat limited", Visibility: models.VisibilityPublic, MaxViews: 5, UserID: 2},
+		{ID: 4, Slug: "mine", Title: "Kumquat mine", Visibility: models.VisibilityUnlisted, UserID: 1},
+	}
+
+	index, err := search.New(search.MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Add(search.Document{ID: 1, Title: "Kumquat public", OwnerID: 2, Public: true})
+	index.Add(search.Document{ID: 2, Title: "Kumquat unlisted", OwnerID: 2, Public: true})
+	index.Add(search.Document{ID: 3, Title: "Kumquat limited", OwnerID: 2, Public: true})
+	index.Add(search.Document{ID: 4, Title: "Kumquat mine", OwnerID: 1})
+	app.searchIndex = index
+
+	ts := newTestServer(t, app)
+
+	code, _, body := ts.get(t, "/search?q=kumquat")
+	if code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", code, http.StatusOK)
+	}
+
+	for _, slug := range []string{"public", "mine"} {
+		if !strings.Contains(body, "/snippet/view/"+slug) {
+			t.Errorf("want results to include %q", slug)
+		}
+	}
+	for _, slug := range []string{"unlisted", "limited"} {
+		if strings.Contains(body, "/snippet/view/"+slug) {
+			t.Errorf("want results not to include %q", slug)
+		}
+	}
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"regexp"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"snippetbox.jamespaul.com/internal/highlight"
+	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/validator"
 )
 
 // The serverError helper writes an error message and stack trace to the errorLog,
@@ -34,6 +43,42 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound) 
 }
 
+// The validationErrorText helper sends the problems found by a validator as
+// a plain text 422 Unprocessable Entity response, one per line, for clients
+// which aren't browsers.
+func (app *application) validationErrorText(w http.ResponseWriter, v validator.Validator) {
+	keys := make([]string, 0, len(v.FieldErrors))
+	for key := range v.FieldErrors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+
+	for _, message := range v.NonFieldErrors {
+		fmt.Fprintln(w, message)
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s: %s\n", key, v.FieldErrors[key])
+	}
+}
+
+// The errorPage helper renders the error.tmpl page with the given status code
+// and message. Unlike clientError, this keeps the user inside the normal
+// application layout (navigation, flash messages and so on).
+func (app *application) errorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := app.newTemplateData(r)
+	data.ErrorTitle = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	data.ErrorMessage = message
+	app.render(w, status, "error.tmpl", data)
+}
+
+// The forbidden helper sends a 403 Forbidden response using the error page.
+func (app *application) forbidden(w http.ResponseWriter, r *http.Request) {
+	app.errorPage(w, r, http.StatusForbidden, "You don't have permission to do that.")
+}
+
 
 func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) { 
 	ts, ok := app.templateCache[page]
@@ -75,10 +120,23 @@ func (app *application) newTemplateData(r *http.Request) *templateData {
 		Flash: app.sessionManager.PopString(r.Context(), "flash"),
 		// Add the authentication status to the template data. 
 		IsAuthenticated: app.isAuthenticated(r),
+		AuthenticatedUserID: app.authenticatedUserID(r),
 		CSRFToken: nosurf.Token(r),
+		Theme: app.theme(r),
 	} 
 }
 
+// The theme method returns the syntax highlighting theme chosen for the
+// current session, or the default theme if none has been chosen.
+func (app *application) theme(r *http.Request) string {
+	theme := app.sessionManager.GetString(r.Context(), "theme")
+	if !highlight.ValidTheme(theme) {
+		return highlight.DefaultTheme
+	}
+
+	return theme
+}
+
 
 // Create a new decodePostForm() helper method. The second parameter here, dst, 
 // is the target destination that we want to decode the form data into.
@@ -119,4 +177,224 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	
 	return isAuthenticated
-}
\ No newline at end of file
+}
+
+// The logIn helper logs the user with the given ID in to the current
+// session. The session records the user's session version, so that it's
+// logged out again if all of their sessions are revoked.
+func (app *application) logIn(r *http.Request, id int) error {
+	version, err := app.users.SessionVersion(id)
+	if err != nil {
+		return err
+	}
+
+	// Use the RenewToken() method on the current session to change the session 
+	// ID. It's good practice to generate a new session ID when the
+	// authentication state or privilege levels changes for the user (e.g. login 
+	// and logout operations).
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// Add the ID of the current user to the session, so that they are now 
+	// 'logged in'.
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.sessionManager.Put(r.Context(), "sessionVersion", version)
+
+	return nil
+}
+
+// The authenticatedUserID helper returns the ID of the current user, or 0 if
+// the request is not authenticated.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	id, ok := r.Context().Value(authenticatedUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// The apiToken helper returns the API token used to authenticate the request,
+// or nil if the request wasn't made with a token.
+func (app *application) apiToken(r *http.Request) *models.APIToken {
+	token, ok := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+	if !ok {
+		return nil
+	}
+
+	return token
+}
+
+// The needsVerification helper reports whether the current user has to
+// verify their email address before they can create snippets. It's always
+// false for anonymous requests, which are dealt with separately.
+func (app *application) needsVerification(r *http.Request) (bool, error) {
+	if !app.requireVerification || !app.isAuthenticated(r) {
+		return false, nil
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		return false, err
+	}
+
+	return !user.EmailVerified, nil
+}
+
+// filenameRX matches runs of characters which aren't safe to use in a
+// filename.
+var filenameRX = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// The snippetFilename helper returns the filename used when a snippet is
+// downloaded. It's built from the title, with anything other than letters,
+// digits, dots, underscores and hyphens replaced by hyphens, and the usual
+// extension for the snippet's language. If nothing is left of the title then
+// the slug is used instead.
+func snippetFilename(snippet *models.Snippet) string {
+	name := strings.Trim(filenameRX.ReplaceAllString(snippet.Title, "-"), "-.")
+	if name == "" {
+		name = snippet.Slug
+	}
+	if len(name) > 100 {
+		name = name[:100]
+	}
+
+	ext := highlight.Extension(snippet.Language)
+	if snippet.Format == models.FormatMarkdown {
+		ext = ".md"
+	}
+
+	return name + ext
+}
+
+// An envelope wraps the data in a JSON response from the API, so that every
+// response is an object with a descriptive key such as "snippet" or "error".
+type envelope map[string]any
+
+// An apiError is the body of every error response sent by the API. Problems
+// found by a validator.Validator are given in FieldErrors and NonFieldErrors,
+// exactly as they would be shown on the matching HTML form.
+type apiError struct {
+	Message string `json:"message"`
+	FieldErrors map[string]string `json:"field_errors,omitempty"`
+	NonFieldErrors []string `json:"non_field_errors,omitempty"`
+}
+
+// The writeJSON helper sends data as a JSON response with the given status
+// code.
+func (app *application) writeJSON(w http.ResponseWriter, status int, data any) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// maxJSONBytes is the largest request body that readJSON will accept.
+const maxJSONBytes = 1_048_576
+
+// The readJSON helper decodes a JSON request body into dst. Unknown fields,
+// trailing data and oversized bodies are all rejected, and the error returned
+// describes the problem in a way that can be shown to the client.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("body contains the wrong type for the %q field", unmarshalTypeError.Field)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		default:
+			return err
+		}
+	}
+
+	// Make sure the body only held a single JSON value.
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// The apiErrorResponse helper sends an error envelope with the given status
+// code and message.
+func (app *application) apiErrorResponse(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, envelope{"error": apiError{Message: message}})
+}
+
+// The apiServerError helper is the API's version of serverError. The error
+// is logged, and the client is sent a generic message.
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+
+	message := "the server encountered a problem and could not process your request"
+	js, _ := json.Marshal(envelope{"error": apiError{Message: message}})
+
+	// This can't call writeJSON, which reports its own failures here.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(append(js, '\n'))
+}
+
+// The apiNotFound helper sends a 404 Not Found error envelope.
+func (app *application) apiNotFound(w http.ResponseWriter) {
+	app.apiErrorResponse(w, http.StatusNotFound, "the requested resource could not be found")
+}
+
+// The apiValidationError helper sends the problems found by a validator as
+// a 422 Unprocessable Entity error envelope.
+func (app *application) apiValidationError(w http.ResponseWriter, v validator.Validator) {
+	app.writeJSON(w, http.StatusUnprocessableEntity, envelope{"error": apiError{
+		Message: "the request could not be processed because of validation errors",
+		FieldErrors: v.FieldErrors,
+		NonFieldErrors: v.NonFieldErrors,
+	}})
+}
+
+// The apiInvalidCredentials helper sends a 401 Unauthorized error envelope
+// for requests with a missing, malformed or expired API token.
+func (app *application) apiInvalidCredentials(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	app.apiErrorResponse(w, http.StatusUnauthorized, "invalid or expired authentication token")
+}
+
+// The humanWait helper formats a duration for telling users how long to
+// wait, rounded up to whole seconds or minutes.
+func humanWait(d time.Duration) string {
+	unit, n := "second", int(math.Ceil(d.Seconds()))
+	if d > time.Minute {
+		unit, n = "minute", int(math.Ceil(d.Minutes()))
+	}
+
+	if n == 1 {
+		return "1 " + unit
+	}
+
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -1,100 +1,324 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"text/template"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
+	"snippetbox.jamespaul.com/internal/loginlimit"
+	"snippetbox.jamespaul.com/internal/mailer"
 	"snippetbox.jamespaul.com/internal/models"
+	"snippetbox.jamespaul.com/internal/search"
 )
 
 // a) Improved Logger // Inject dependencies
-type application struct { 
-	errorLog *log.Logger 
-	infoLog *log.Logger
-	snippets *models.SnippetModel
-	users *models.UserModel
-	templateCache map[string]*template.Template
-	formDecoder *form.Decoder
+type application struct {
+	errorLog       *log.Logger
+	infoLog        *log.Logger
+	snippets       models.SnippetModelInterface
+	revisions      *models.RevisionModel
+	users          *models.UserModel
+	apiTokens      *models.APITokenModel
+	emailTokens    *models.EmailTokenModel
+	twoFactor      *models.TwoFactorModel
+	loginAudit     *models.LoginAuditModel
+	templateCache  map[string]*template.Template
+	emailTemplates map[string]*texttemplate.Template
+	mailer         mailer.Mailer
+	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	// searchIndex is nil when search is handled by MySQL FULLTEXT indexes.
+	searchIndex *search.Index
+	// pasteLimiter limits how often anonymous clients can create snippets
+	// through the /paste endpoint.
+	pasteLimiter *ipRateLimiter
+	// accountLimiter and ipLimiter slow down password guessing, by counting
+	// failed logins for each account and each IP address. They share
+	// loginFailures.
+	accountLimiter *loginlimit.Limiter
+	ipLimiter      *loginlimit.Limiter
+	loginFailures  loginlimit.Store
+	// requireVerification stops users from creating snippets until they've
+	// verified their email address.
+	requireVerification bool
+	// requireTwoFactorAll makes every user turn on two-factor
+	// authentication before they can do anything else once logged in.
+	requireTwoFactorAll bool
+	// baseURL is the scheme and host the application is served from, such
+	// as "https://snippetbox.example.com", used for links in emails and the
+	// snippet URLs returned by /paste and the API.
+	baseURL string
+	wg      sync.WaitGroup
 }
 
+// loginFailureWindow is how long after the last failed login for an account
+// or IP address all of its failures are forgotten.
+const loginFailureWindow = 24 * time.Hour
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
+	baseURL := flag.String("base-url", "http://localhost:4000", "Scheme and host the application is served from, used for links in emails and snippet URLs returned by /paste and the API")
 	dsn := flag.String("dsn", "web:pass@/snippetbox?parseTime=true", "MySQL data source name")
-	
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "How long deleted snippets are kept in the trash")
+	sweepInterval := flag.Duration("sweep-interval", 5*time.Minute, "How often to delete expired snippets")
+	sweepBatch := flag.Int("sweep-batch", 1000, "Maximum number of expired snippets to delete per statement")
+	searchIndexPath := flag.String("search-index", "", "Path of the in-process search index file (search uses MySQL FULLTEXT if empty)")
+	pasteRate := flag.Float64("paste-rate", 10, "Anonymous snippets each IP address can create through /paste per minute")
+	pasteBurst := flag.Int("paste-burst", 5, "Anonymous snippets each IP address can create through /paste at once")
+	requireVerification := flag.Bool("require-verified-email", true, "Only let users create snippets once they've verified their email address")
+	requireTwoFactor := flag.Bool("require-2fa", false, "Make every user turn on two-factor authentication")
+	loginStore := flag.String("login-store", "mysql", "Where to keep counts of failed logins (mysql or memory)")
+	loginMaxFailures := flag.Int("login-max-failures", 10, "Failed logins which lock an account")
+	loginLockout := flag.Duration("login-lockout", 15*time.Minute, "How long an account is locked after too many failed logins")
+	loginAuditRetention := flag.Duration("login-audit-retention", 90*24*time.Hour, "How long failed logins are kept in the audit log")
+	smtpHost := flag.String("smtp-host", "", "SMTP server host (emails are written to the info log if empty)")
+	smtpPort := flag.Int("smtp-port", 587, "SMTP server port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpSender := flag.String("smtp-sender", "Snippetbox <no-reply@snippetbox.jamespaul.com>", "SMTP sender address")
+
 	flag.Parse()
-	
+
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	
-	db, err := openDB(*dsn) 
+
+	// Links in emails are built from the configured base URL rather than the
+	// Host header of the request, which an attacker could set to their own
+	// server to capture password reset tokens.
+	base, err := url.Parse(*baseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		errorLog.Fatalf("-base-url must be an absolute http or https URL, not %q", *baseURL)
+	}
+
+	db, err := openDB(*dsn)
 
 	if err != nil {
-		errorLog.Fatal(err) 
+		errorLog.Fatal(err)
 	}
 
 	defer db.Close()
 
 	// Initialize a new template cache...
-	templateCache, err := newTemplateCache() 
+	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err) 
+		errorLog.Fatal(err)
+	}
+
+	emailTemplates, err := newEmailTemplateCache()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Send emails through an SMTP server if one has been configured, and
+	// otherwise just log them so that the links in them can be followed
+	// during development.
+	var mail mailer.Mailer = &mailer.Log{Logger: infoLog}
+	if *smtpHost != "" {
+		mail = mailer.NewSMTP(*smtpHost, *smtpPort, *smtpUsername, *smtpPassword, *smtpSender)
+	}
+
+	// Failed logins are counted in MySQL by default, so that the counts are
+	// shared between servers and survive restarts.
+	var loginFailures loginlimit.Store
+	switch *loginStore {
+	case "mysql":
+		loginFailures = &models.LoginFailureModel{DB: db}
+	case "memory":
+		loginFailures = loginlimit.NewMemoryStore()
+	default:
+		errorLog.Fatalf("unknown -login-store %q", *loginStore)
+	}
+
+	// Each account gets a few free guesses, then has to wait longer and
+	// longer between them until it's locked. IP addresses get more, since
+	// many users can share one.
+	accountLimiter := &loginlimit.Limiter{
+		Store: loginFailures,
+		Policy: loginlimit.Policy{
+			Free:         3,
+			Delay:        time.Second,
+			MaxDelay:     5 * time.Minute,
+			LockoutAfter: *loginMaxFailures,
+			Lockout:      *loginLockout,
+			Forget:       loginFailureWindow,
+		},
+	}
+	ipLimiter := &loginlimit.Limiter{
+		Store: loginFailures,
+		Policy: loginlimit.Policy{
+			Free:         20,
+			Delay:        time.Second,
+			MaxDelay:     5 * time.Minute,
+			LockoutAfter: 5 * *loginMaxFailures,
+			Lockout:      *loginLockout,
+			Forget:       loginFailureWindow,
+		},
 	}
 
 	// Initialize a decoder instance...
 	formDecoder := form.NewDecoder()
 
-	// Use the scs.New() function to initialize a new session manager. Then we 
-	// configure it to use our MySQL database as the session store, and set a 
+	// Use the scs.New() function to initialize a new session manager. Then we
+	// configure it to use our MySQL database as the session store, and set a
 	// lifetime of 12 hours (so that sessions automatically expire 12 hours
 	// after first being created).
-	sessionManager := scs.New() 
-	sessionManager.Store = mysqlstore.New(db) 
+	sessionManager := scs.New()
+	sessionManager.Store = mysqlstore.New(db)
 	sessionManager.Lifetime = 12 * time.Hour
 
-	// Initialize a models.SnippetModel instance and add it to the application 
-	// dependencies.
+	// Initialize a models.SnippetModel instance and add it to the application
+	// dependencies. We keep hold of the concrete model too, since the search
+	// index needs to be hooked up to it below.
+	snippets := &models.SnippetModel{DB: db}
+
 	app := &application{
-		errorLog: errorLog,
-		infoLog: infoLog,
-		snippets: &models.SnippetModel{DB: db},
-		users: &models.UserModel{DB: db},
-		templateCache: templateCache,
-		formDecoder: formDecoder,
-		sessionManager: sessionManager,
+		errorLog:            errorLog,
+		infoLog:             infoLog,
+		snippets:            snippets,
+		revisions:           &models.RevisionModel{DB: db},
+		users:               &models.UserModel{DB: db},
+		apiTokens:           &models.APITokenModel{DB: db},
+		emailTokens:         &models.EmailTokenModel{DB: db},
+		twoFactor:           &models.TwoFactorModel{DB: db},
+		loginAudit:          &models.LoginAuditModel{DB: db},
+		templateCache:       templateCache,
+		emailTemplates:      emailTemplates,
+		mailer:              mail,
+		formDecoder:         formDecoder,
+		sessionManager:      sessionManager,
+		pasteLimiter:        newIPRateLimiter(*pasteRate, *pasteBurst),
+		accountLimiter:      accountLimiter,
+		ipLimiter:           ipLimiter,
+		loginFailures:       loginFailures,
+		requireVerification: *requireVerification,
+		requireTwoFactorAll: *requireTwoFactor,
+		baseURL:             strings.TrimSuffix(*baseURL, "/"),
+	}
+
+	// If an in-process search index has been configured, load it and hook it
+	// up to the snippet model so that it's updated whenever a snippet changes.
+	// An empty index is built from the database before we start serving, so
+	// the first run doesn't need a separate rebuild.
+	if *searchIndexPath != "" {
+		index, err := search.New(&search.FileStore{Path: *searchIndexPath})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+
+		if index.Len() == 0 {
+			n, err := search.RebuildSnippets(index, snippets)
+			if err != nil {
+				errorLog.Fatal(err)
+			}
+			infoLog.Printf("Built search index of %d snippets", n)
+		}
+
+		app.searchIndex = index
+		snippets.Indexer = search.SnippetIndexer{Index: index}
 	}
 
-	srv := &http.Server{ 
-		Addr: *addr,
+	// Create a context which is cancelled when the application receives a
+	// SIGINT or SIGTERM signal. This is used to stop the HTTP server and the
+	// background workers.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start the background workers which permanently remove snippets that
+	// have been in the trash for longer than the retention period, and
+	// snippets which have expired.
+	app.background("trash purge", func() {
+		app.purgeTrash(ctx, time.Hour, *trashRetention)
+	})
+	app.background("expiry sweep", func() {
+		app.sweepExpired(ctx, *sweepInterval, *sweepBatch)
+	})
+	app.background("email token sweep", func() {
+		app.sweepEmailTokens(ctx, time.Hour)
+	})
+	app.background("login failure prune", func() {
+		app.pruneLoginFailures(ctx, time.Hour, *loginAuditRetention)
+	})
+	app.background("paste limiter prune", func() {
+		app.prunePasteLimiter(ctx, time.Minute)
+	})
+	if app.searchIndex != nil {
+		app.background("search index flush", func() {
+			app.flushSearchIndex(ctx, time.Minute)
+		})
+	}
+
+	srv := &http.Server{
+		Addr:     *addr,
 		ErrorLog: errorLog,
-		Handler: app.routes(), 
+		Handler:  app.routes(),
 	}
 
-	infoLog.Printf("Starting server on http://localhost%s", *addr) 
+	// Once a shutdown signal has been received, give any in-flight requests
+	// up to 30 seconds to complete.
+	shutdownError := make(chan error)
+
+	go func() {
+		<-ctx.Done()
+
+		infoLog.Print("Shutting down server")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		shutdownError <- srv.Shutdown(shutdownCtx)
+	}()
+
+	infoLog.Printf("Starting server on http://localhost%s", *addr)
 	err = srv.ListenAndServe()
-	errorLog.Fatal(err)
+	if !errors.Is(err, http.ErrServerClosed) {
+		errorLog.Fatal(err)
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Wait for the background workers to finish what they're doing.
+	app.wg.Wait()
+
+	// Save any search index changes made since the last flush.
+	if app.searchIndex != nil {
+		err = app.searchIndex.Flush()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	infoLog.Print("Server stopped")
 }
 
 // for a given DSN.
-func openDB(dsn string) (*sql.DB, error) { 
+func openDB(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		return nil, err 
+		return nil, err
 	}
 
-	if err = db.Ping(); err != nil { 
+	if err = db.Ping(); err != nil {
 		return nil, err
 	}
 
-	return db, nil 
-}
\ No newline at end of file
+	return db, nil
+}
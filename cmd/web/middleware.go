@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
+	"snippetbox.jamespaul.com/internal/models"
 )
 func secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -70,6 +74,52 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 }
 
 
+// The requireTwoFactor middleware sends users who haven't turned on
+// two-factor authentication to the page for setting it up, if it's required
+// for everyone. It must be used after requireAuthentication.
+func (app *application) requireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.requireTwoFactorAll {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if !user.TwoFactorEnabled {
+			app.sessionManager.Put(r.Context(), "flash", "Please turn on two-factor authentication to continue.")
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireVerifiedEmail middleware stops users who haven't verified their
+// email address from going any further, if verification is required. It
+// must be used after requireAuthentication.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		unverified, err := app.needsVerification(r)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		if unverified {
+			app.renderUnverified(w, r, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // Create a NoSurf middleware function which uses a customized CSRF cookie with 
 // the Secure, Path and HttpOnly attributes set.
 func noSurf(next http.Handler) http.Handler {
@@ -95,22 +145,196 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// Otherwise, we check to see if a user with that ID exists in our 
-		// database.
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		// database, and that this session was logged in since their sessions
+		// were last revoked (for example by resetting their password).
+		version, err := app.users.SessionVersion(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, err)
 			return
 		}
 
+		// If not, the session is no longer logged in, so we forget the user
+		// and carry on as if the request were anonymous.
+		if err != nil || version != app.sessionManager.GetInt(r.Context(), "sessionVersion") {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// If a matching user is found, we know we know that the request is
 		// coming from an authenticated user who exists in our database. We
 		// create a new copy of the request (with an isAuthenticatedContextKey 
-		// value of true in the request context) and assign it to r.
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx) 
-	}
+		// value of true in the request context) and assign it to r. We also
+		// store the user's ID so that handlers can tell who they are.
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, id)
+		r = r.WithContext(ctx) 
+
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r) 
 	})
-}
\ No newline at end of file
+}
+
+// The requireSnippetOwner middleware loads the snippet identified by the :slug
+// route parameter and checks that it belongs to the current user. It must be
+// used after requireAuthentication. On success the snippet is stored in the
+// request context under snippetContextKey.
+func (app *application) requireSnippetOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		snippet, err := app.snippets.Get(params.ByName("slug"))
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		userID := app.authenticatedUserID(r)
+
+		// Don't give away that a private snippet exists to anyone who
+		// can't see it.
+		if !snippet.VisibleTo(userID) {
+			app.notFound(w)
+			return
+		}
+
+		// Snippets created before ownership was recorded aren't owned by
+		// anyone, so nobody is allowed to modify those.
+		if !snippet.OwnedBy(userID) {
+			app.forbidden(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), snippetContextKey, snippet)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The rateLimitPaste middleware limits how many snippets each IP address can
+// create anonymously through the /paste endpoint, responding with 429 Too
+// Many Requests once a client has used up its allowance. Requests made with
+// an API token aren't limited.
+func (app *application) rateLimitPaste(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) && !app.pasteLimiter.Allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "60")
+			app.clientError(w, http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The authenticateToken middleware is the API's version of authenticate. API
+// clients don't have sessions, so they send one of the user's API tokens with
+// every request in an "Authorization: Bearer" header. On success the request
+// context is filled in exactly as authenticate would, plus the token itself
+// so that its scopes can be checked. Requests without a token carry on
+// anonymously, but bad tokens are rejected outright so that a client doesn't
+// silently lose access to its own snippets.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		parts := strings.Fields(header)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			app.apiInvalidCredentials(w)
+			return
+		}
+
+		token, err := app.apiTokens.Authenticate(parts[1])
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				app.apiInvalidCredentials(w)
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, authenticatedUserIDContextKey, token.UserID)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The requireScope middleware rejects requests authenticated with an API
+// token which hasn't been given the scope. Anonymous requests are let
+// through, so it can be used on endpoints which anyone may call.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := app.apiToken(r)
+			if token != nil && !token.HasScope(scope) {
+				app.apiErrorResponse(w, http.StatusForbidden, fmt.Sprintf("this token does not have the %s scope", scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The requireAPIAuthentication middleware is the API's version of
+// requireAuthentication, sending a 401 Unauthorized error envelope instead of
+// redirecting to the login page.
+func (app *application) requireAPIAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiErrorResponse(w, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAPISnippetOwner middleware is the API's version of
+// requireSnippetOwner. Snippets which the user can't see at all get a 404,
+// as they would from the snippet endpoint, rather than revealing that they
+// exist.
+func (app *application) requireAPISnippetOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		snippet, err := app.snippets.Get(params.ByName("slug"))
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiNotFound(w)
+			} else {
+				app.apiServerError(w, err)
+			}
+			return
+		}
+
+		userID := app.authenticatedUserID(r)
+
+		if !snippet.VisibleTo(userID) {
+			app.apiNotFound(w)
+			return
+		}
+
+		if !snippet.OwnedBy(userID) {
+			app.apiErrorResponse(w, http.StatusForbidden, "you don't have permission to modify this snippet")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), snippetContextKey, snippet)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/justinas/alice"
+	"snippetbox.jamespaul.com/internal/models"
+)
+
+func TestRequireSnippetOwner(t *testing.T) {
+	app := newTestApplication(t)
+	app.snippets.(*stubSnippetModel).snippets = []*models.Snippet{
+		{ID: 1, Slug: "mine", Visibility: models.VisibilityPrivate, UserID: 1},
+		{ID: 2, Slug: "theirs", Visibility: models.VisibilityPublic, UserID: 2},
+		{ID: 3, Slug: "unlisted", Visibility: models.VisibilityUnlisted, UserID: 2},
+		{ID: 4, Slug: "private", Visibility: models.VisibilityPrivate, UserID: 2},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	router := httprouter.New()
+	chain := alice.New(app.sessionManager.LoadAndSave, logInAs(1), app.requireSnippetOwner)
+	router.Handler(http.MethodGet, "/snippet/edit/:slug", chain.Then(ok))
+
+	ts := newTestServerWithHandler(t, router)
+
+	tests := []struct {
+		slug string
+		want int
+	}{
+		{"mine", http.StatusOK},
+		{"theirs", http.StatusForbidden},
+		{"unlisted", http.StatusForbidden},
+		{"private", http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			code, _, _ := ts.get(t, "/snippet/edit/"+tt.slug)
+			if code != tt.want {
+				t.Errorf("got status %d; want %d", code, tt.want)
+			}
+		})
+	}
+}
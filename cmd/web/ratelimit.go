@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// An ipRateLimiter keeps a token bucket for each client IP address. Buckets
+// which have filled up again are thrown away by prune(), so the map doesn't
+// grow forever.
+type ipRateLimiter struct {
+	mu sync.Mutex
+	clients map[string]*rate.Limiter
+	limit rate.Limit
+	burst int
+}
+
+// newIPRateLimiter returns an ipRateLimiter which allows each client burst
+// requests at once, refilled at perMinute requests per minute.
+func newIPRateLimiter(perMinute float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		clients: make(map[string]*rate.Limiter),
+		limit: rate.Limit(perMinute / 60),
+		burst: burst,
+	}
+}
+
+// Allow reports whether the client with the given IP address may make a
+// request now, using up one of its tokens if so.
+func (l *ipRateLimiter) Allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.clients[ip]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.clients[ip] = limiter
+	}
+
+	return limiter.Allow()
+}
+
+// prune forgets any clients whose buckets are full, since a new bucket would
+// behave in exactly the same way.
+func (l *ipRateLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for ip, limiter := range l.clients {
+		if limiter.TokensAt(now) >= float64(l.burst) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// The clientIP helper returns the IP address a request came from, without
+// the port.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
+	"snippetbox.jamespaul.com/internal/models"
 )
 
 // Update the signature for the routes() method so that it returns a
@@ -18,16 +19,32 @@ func (app *application) routes() http.Handler {
 
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
 	router.Handler(http.MethodGet, "/static/*filepath", http.StripPrefix("/static", fileServer)) 
+	router.HandlerFunc(http.MethodGet, "/theme/:file", app.themeCSS)
 	
 	// Unprotected application routes using the "dynamic" middleware chain.
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home)) 
-	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippets", dynamic.ThenFunc(app.snippetList))
+	router.Handler(http.MethodGet, "/tag/:name", dynamic.ThenFunc(app.tagView))
+	router.Handler(http.MethodGet, "/search", dynamic.ThenFunc(app.search))
+	router.Handler(http.MethodGet, "/snippet/view/:slug", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/raw/:slug", dynamic.ThenFunc(app.snippetRaw))
+	router.Handler(http.MethodGet, "/snippet/download/:slug", dynamic.ThenFunc(app.snippetDownload))
+	router.Handler(http.MethodGet, "/snippet/view/:slug/history", dynamic.ThenFunc(app.snippetHistory))
+	router.Handler(http.MethodGet, "/snippet/view/:slug/diff", dynamic.ThenFunc(app.snippetDiff))
+	router.Handler(http.MethodPost, "/theme", dynamic.ThenFunc(app.themePost))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup)) 
 	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin)) 
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userForgotPassword))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userForgotPasswordPost))
+	router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.userResetPassword))
+	router.Handler(http.MethodPost, "/user/password/reset", dynamic.ThenFunc(app.userResetPasswordPost))
+	router.Handler(http.MethodGet, "/user/verify", dynamic.ThenFunc(app.userVerifyEmail))
+	router.Handler(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	router.Handler(http.MethodPost, "/user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
 	
 	// Protected (authenticated-only) application routes, using a new "protected" 
 	// middleware chain which includes the requireAuthentication middleware. 
@@ -35,10 +52,74 @@ func (app *application) routes() http.Handler {
 	// the noSurf middleware will also be used on the three routes below too.
 	protected := dynamic.Append(app.requireAuthentication)
 
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate)) 
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost)) 
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodGet, "/user/2fa", protected.ThenFunc(app.userTwoFactor))
+	router.Handler(http.MethodGet, "/user/2fa/qr.png", protected.ThenFunc(app.userTwoFactorQR))
+	router.Handler(http.MethodPost, "/user/2fa/enable", protected.ThenFunc(app.userTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/user/2fa/disable", protected.ThenFunc(app.userTwoFactorDisablePost))
+	router.Handler(http.MethodPost, "/user/2fa/recovery-codes", protected.ThenFunc(app.userRecoveryCodesPost))
+
+	// Everything else needs two-factor authentication to be turned on, when
+	// the -require-2fa flag is set.
+	enrolled := protected.Append(app.requireTwoFactor)
+
+	router.Handler(http.MethodGet, "/user/verify/resend", enrolled.ThenFunc(app.userVerifyEmailResend))
+	router.Handler(http.MethodPost, "/user/verify/resend", enrolled.ThenFunc(app.userVerifyEmailResendPost))
+	router.Handler(http.MethodGet, "/account", enrolled.ThenFunc(app.account))
+	router.Handler(http.MethodPost, "/account/name", enrolled.ThenFunc(app.accountNamePost))
+	router.Handler(http.MethodPost, "/account/email", enrolled.ThenFunc(app.accountEmailPost))
+	router.Handler(http.MethodPost, "/account/password", enrolled.ThenFunc(app.accountPasswordPost))
+	router.Handler(http.MethodGet, "/user/trash", enrolled.ThenFunc(app.userTrash))
+	router.Handler(http.MethodPost, "/snippet/restore/:slug", enrolled.ThenFunc(app.snippetRestorePost))
+	router.Handler(http.MethodPost, "/snippet/purge/:slug", enrolled.ThenFunc(app.snippetPurgePost))
+	router.Handler(http.MethodGet, "/user/tokens", enrolled.ThenFunc(app.userTokens))
+	router.Handler(http.MethodPost, "/user/tokens", enrolled.ThenFunc(app.userTokensPost))
+	router.Handler(http.MethodPost, "/user/tokens/revoke/:id", enrolled.ThenFunc(app.userTokenRevokePost))
+
+	// Creating snippets may also need a verified email address.
+	verified := enrolled.Append(app.requireVerifiedEmail)
+
+	router.Handler(http.MethodGet, "/snippet/create", verified.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create", verified.ThenFunc(app.snippetCreatePost))
+
+	// Routes which act on an existing snippet, and so are restricted to the
+	// snippet's owner.
+	owner := enrolled.Append(app.requireSnippetOwner)
+
+	router.Handler(http.MethodGet, "/snippet/edit/:slug", owner.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:slug", owner.ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/delete/:slug", owner.ThenFunc(app.snippetDeletePost))
 	
+	// The /paste endpoint is used from the terminal with curl rather than
+	// from a browser, so it doesn't use sessions or CSRF protection. Clients
+	// can authenticate with an API token, and anonymous pastes are rate
+	// limited per IP address.
+	paste := alice.New(app.authenticateToken, app.requireScope(models.ScopeSnippetsWrite), app.rateLimitPaste)
+
+	router.Handler(http.MethodPost, "/paste", paste.ThenFunc(app.snippetPaste))
+
+	// The JSON API for programs rather than people. Like /paste it doesn't
+	// use sessions or CSRF protection; clients send an API token with every
+	// request instead, and each endpoint checks the token's scopes.
+	api := alice.New(app.authenticateToken)
+	apiRead := api.Append(app.requireScope(models.ScopeSnippetsRead))
+
+	router.Handler(http.MethodGet, "/api/v1/snippets", apiRead.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:slug", apiRead.ThenFunc(app.apiSnippetView))
+
+	apiProtected := api.Append(app.requireAPIAuthentication)
+
+	router.Handler(http.MethodGet, "/api/v1/me", apiProtected.ThenFunc(app.apiMe))
+
+	apiWrite := apiProtected.Append(app.requireScope(models.ScopeSnippetsWrite))
+
+	router.Handler(http.MethodPost, "/api/v1/snippets", apiWrite.ThenFunc(app.apiSnippetCreate))
+
+	apiOwner := apiWrite.Append(app.requireAPISnippetOwner)
+
+	router.Handler(http.MethodPatch, "/api/v1/snippets/:slug", apiOwner.ThenFunc(app.apiSnippetUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:slug", apiOwner.ThenFunc(app.apiSnippetDelete))
+
 	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
 	return standard.Then(router)
 }
\ No newline at end of file
@@ -2,9 +2,11 @@ package main
 
 import (
 	"path/filepath"
-	"text/template"
+	"html/template"
 	"time"
 
+	"snippetbox.jamespaul.com/internal/diff"
+	"snippetbox.jamespaul.com/internal/highlight"
 	"snippetbox.jamespaul.com/internal/models"
 )
 
@@ -15,11 +17,41 @@ import (
 type templateData struct { 
 	CurrentYear int
 	Snippet *models.Snippet
+	// Rendered is the snippet's content rendered as HTML, either with syntax
+	// highlighting or from Markdown. It's a template.HTML so that it isn't
+	// escaped a second time, which means it must only ever hold output from
+	// the highlight and markdown packages, which do their own escaping.
+	Rendered template.HTML
 	Snippets []*models.Snippet
+	NextCursor string
+	PrevCursor string
+	Tag string
+	SearchResults []searchResult
+	NextPage int
+	PrevPage int
+	Revisions []*models.Revision
+	FromRevision *models.Revision
+	ToRevision *models.Revision
+	DiffHunks []diff.Hunk
+	APITokens []*models.APIToken
+	User *models.User
+	// TOTPSecret and TOTPURI describe the two-factor authentication secret
+	// which is being set up.
+	TOTPSecret string
+	TOTPURI string
+	RecoveryCodes []string
+	RecoveryCodesLeft int
+	// NewAPIToken is a token which has just been created, and is about to
+	// be shown to the user for the only time.
+	NewAPIToken string
 	Form any
 	Flash string
 	IsAuthenticated bool
+	AuthenticatedUserID int
 	CSRFToken string
+	Theme string
+	ErrorTitle string
+	ErrorMessage string
 }
 
 func newTemplateCache() (map[string]*template.Template, error) { 
@@ -63,9 +95,21 @@ func newTemplateCache() (map[string]*template.Template, error) {
 }
 
 // Create a humanDate function which returns a nicely formatted string 
-// representation of a time.Time object.
+// representation of a time.Time object in UTC. The zero time is used for
+// snippets which never expire, so we return an empty string for it rather
+// than a date in the year 1.
 func humanDate(t time.Time) string {
-	return t.Format("02 Jan 2006 at 15:04") 
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format("02 Jan 2006 at 15:04") 
+}
+
+// Create a sub function for doing simple arithmetic in templates, such as
+// working out the previous revision number.
+func sub(a, b int) int {
+	return a - b
 }
 
 // Initialize a template.FuncMap object and store it in a global variable. This is 
@@ -73,5 +117,10 @@ func humanDate(t time.Time) string {
 // custom template functions and the functions themselves.
 var functions = template.FuncMap{
 	"humanDate": humanDate, 
+	"sub": sub,
+	"languageName": highlight.Name,
+	"languages": func() []highlight.Language { return highlight.Languages },
+	"themes": func() []string { return highlight.Themes },
+	"scopes": func() []string { return models.Scopes },
 }
 	
\ No newline at end of file
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
+	"github.com/justinas/alice"
+	"snippetbox.jamespaul.com/internal/models"
+)
+
+// The templates are loaded with paths relative to the root of the project, so
+// run the tests from there.
+func TestMain(m *testing.M) {
+	err := os.Chdir("../..")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// stubSnippetModel is an in-memory stand-in for models.SnippetModel. It only
+// implements the methods the tests need; calling any of the others panics on
+// the nil embedded interface.
+type stubSnippetModel struct {
+	models.SnippetModelInterface
+
+	mu       sync.Mutex
+	snippets []*models.Snippet
+}
+
+func (m *stubSnippetModel) Insert(userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := len(m.snippets) + 1
+	m.snippets = append(m.snippets, &models.Snippet{
+		ID:         id,
+		Slug:       fmt.Sprintf("stub%d", id),
+		Title:      title,
+		Content:    content,
+		Language:   language,
+		Format:     format,
+		Created:    time.Now(),
+		Expires:    expires,
+		Visibility: visibility,
+		MaxViews:   maxViews,
+		UserID:     userID,
+		UserName:   "Alice",
+		Tags:       tags,
+	})
+
+	return fmt.Sprintf("stub%d", id), nil
+}
+
+func (m *stubSnippetModel) Get(slug string) (*models.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.snippets {
+		if s.Slug == slug {
+			copy := *s
+			return &copy, nil
+		}
+	}
+
+	return nil, models.ErrNoRecord
+}
+
+func (m *stubSnippetModel) Update(id int, userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.snippets {
+		if s.ID == id {
+			s.Title, s.Content, s.Language, s.Format = title, content, language, format
+			s.Expires, s.Visibility, s.MaxViews, s.Tags = expires, visibility, maxViews, tags
+			return nil
+		}
+	}
+
+	return models.ErrNoRecord
+}
+
+func (m *stubSnippetModel) GetMany(ids []int) ([]*models.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snippets := []*models.Snippet{}
+	for _, id := range ids {
+		for _, s := range m.snippets {
+			if s.ID == id {
+				copy := *s
+				snippets = append(snippets, &copy)
+			}
+		}
+	}
+
+	return snippets, nil
+}
+
+func (m *stubSnippetModel) Latest() ([]*models.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var latest []*models.Snippet
+	for i := len(m.snippets) - 1; i >= 0; i-- {
+		if m.snippets[i].Visibility == models.VisibilityPublic {
+			copy := *m.snippets[i]
+			latest = append(latest, &copy)
+		}
+	}
+
+	return latest, nil
+}
+
+// newTestApplication returns an application using the stub snippet model and
+// an in-memory session store.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		errorLog:       log.New(io.Discard, "", 0),
+		infoLog:        log.New(io.Discard, "", 0),
+		snippets:       &stubSnippetModel{},
+		baseURL:        "https://snippetbox.example.com",
+		templateCache:  templateCache,
+		formDecoder:    form.NewDecoder(),
+		sessionManager: scs.New(),
+	}
+}
+
+// The logInAs middleware marks every request as coming from the user with
+// the given ID, in the same way as the authenticate middleware does for a
+// real session.
+func logInAs(userID int) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserIDContextKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// testServer wraps an httptest.Server with a client which keeps cookies, so
+// that flash messages survive a redirect, but doesn't follow redirects.
+type testServer struct {
+	*httptest.Server
+}
+
+// newTestServer starts a server with the snippet creation, viewing and
+// search routes, logged in as user 1. CSRF protection and the database-backed
+// authentication middleware are left out, since the stub model has no
+// users.
+func newTestServer(t *testing.T, app *application) *testServer {
+	t.Helper()
+
+	router := httprouter.New()
+	chain := alice.New(app.sessionManager.LoadAndSave, logInAs(1))
+
+	router.Handler(http.MethodGet, "/", chain.ThenFunc(app.home))
+	router.Handler(http.MethodPost, "/snippet/create", chain.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/view/:slug", chain.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/search", chain.ThenFunc(app.search))
+
+	return newTestServerWithHandler(t, router)
+}
+
+// newTestServerWithHandler starts a server with the given handler.
+func newTestServerWithHandler(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &testServer{ts}
+}
+
+// get makes a GET request to the given path, returning the status code,
+// headers and body of the response.
+func (ts *testServer) get(t *testing.T, path string) (int, http.Header, string) {
+	t.Helper()
+
+	rs, err := ts.Client().Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return readResponse(t, rs)
+}
+
+// postForm makes a POST request to the given path with the form values in
+// the body.
+func (ts *testServer) postForm(t *testing.T, path string, values url.Values) (int, http.Header, string) {
+	t.Helper()
+
+	rs, err := ts.Client().PostForm(ts.URL+path, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return readResponse(t, rs)
+}
+
+func readResponse(t *testing.T, rs *http.Response) (int, http.Header, string) {
+	t.Helper()
+
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, strings.TrimSpace(string(body))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The background helper runs fn in a new goroutine which the application
+// waits for before shutting down. Any panic in fn is recovered and logged,
+// rather than bringing down the whole server.
+func (app *application) background(name string, fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("%s: %s", name, err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// The purgeTrash method permanently deletes any snippets which have been in
+// the trash for longer than the retention period, checking every interval
+// until ctx is cancelled.
+func (app *application) purgeTrash(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := app.snippets.PurgeTrashed(retention)
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("trash purge: %s", err))
+			continue
+		}
+
+		if n > 0 {
+			app.infoLog.Printf("Purged %d snippets from the trash", n)
+		}
+	}
+}
+
+// The sweepExpired method permanently deletes expired snippets, checking
+// every interval until ctx is cancelled. Each sweep deletes in batches of
+// batchSize until no expired snippets remain, so that a large backlog doesn't
+// lock the snippets table for long.
+func (app *application) sweepExpired(ctx context.Context, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var total int64
+
+		for ctx.Err() == nil {
+			n, err := app.snippets.DeleteExpired(batchSize)
+			if err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("expiry sweep: %s", err))
+				break
+			}
+
+			total += n
+
+			if n < int64(batchSize) {
+				break
+			}
+		}
+
+		if total > 0 {
+			app.infoLog.Printf("Deleted %d expired snippets", total)
+		}
+	}
+}
+
+// The sweepEmailTokens method deletes expired password reset tokens and the
+// like, checking every interval until ctx is cancelled.
+func (app *application) sweepEmailTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		_, err := app.emailTokens.DeleteExpired()
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("email token sweep: %s", err))
+		}
+	}
+}
+
+// The pruneLoginFailures method forgets about failed logins which are too old
+// to count towards the login limits any more, and deletes audit records
+// older than retention, checking every interval until ctx is cancelled.
+func (app *application) pruneLoginFailures(ctx context.Context, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.loginFailures.Prune(time.Now().Add(-loginFailureWindow))
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("login failure prune: %s", err))
+		}
+
+		_, err = app.loginAudit.DeleteOlder(retention)
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("login audit prune: %s", err))
+		}
+	}
+}
+
+// The flushSearchIndex method writes any changes to the search index to disk
+// every interval until ctx is cancelled. The final flush happens in main(),
+// once everything which could change the index has stopped.
+func (app *application) flushSearchIndex(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := app.searchIndex.Flush()
+		if err != nil {
+			app.errorLog.Output(2, fmt.Sprintf("search index flush: %s", err))
+		}
+	}
+}
+
+// The prunePasteLimiter method forgets about clients which haven't used the
+// /paste endpoint recently enough to still be limited, checking every interval until ctx is cancelled.
+func (app *application) prunePasteLimiter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		app.pasteLimiter.prune()
+	}
+}
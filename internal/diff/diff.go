@@ -0,0 +1,333 @@
+// Package diff computes line-based differences between two texts, using the
+// linear space variant of the Myers O(ND) algorithm, and groups them into
+// unified diff hunks.
+package diff
+
+import "strings"
+
+// Op describes what happened to a line when moving from the old text to the
+// new one.
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// String returns a lower-case name for the operation, which is handy for use
+// as a CSS class name.
+func (op Op) String() string {
+	switch op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// maxEdits bounds the work done by the Myers algorithm. If the two texts
+// differ by more than this many lines we give up on finding the shortest
+// edit script and simply report the old text as deleted and the new text as
+// inserted.
+const maxEdits = 2000
+
+// Line is a single line of a diff. OldNum and NewNum are the 1-based line
+// numbers in the old and new texts, and are 0 when the line doesn't appear
+// in that text.
+type Line struct {
+	Op     Op
+	Text   string
+	OldNum int
+	NewNum int
+}
+
+// Hunk is a group of changed lines together with some surrounding context, in
+// the style of a unified diff.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Lines returns the line-by-line difference between a and b.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	// Strip any common prefix and suffix before running the main algorithm.
+	// Edits to snippets tend to be small, so this usually leaves very little
+	// work to do.
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(x)+len(y))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, Equal)
+	}
+	ops = append(ops, myers(x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])...)
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, Equal)
+	}
+
+	// Walk the edit script to attach the text and line numbers to each line.
+	lines := make([]Line, 0, len(ops))
+	i, j := 0, 0
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			lines = append(lines, Line{Op: Equal, Text: x[i], OldNum: i + 1, NewNum: j + 1})
+			i++
+			j++
+		case Delete:
+			lines = append(lines, Line{Op: Delete, Text: x[i], OldNum: i + 1})
+			i++
+		case Insert:
+			lines = append(lines, Line{Op: Insert, Text: y[j], NewNum: j + 1})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// Unified groups the output of Lines into hunks, keeping up to context
+// unchanged lines either side of each change. Unchanged regions larger than
+// that are omitted. If there are no changes, Unified returns nil.
+func Unified(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+
+	start, end := -1, -1
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+
+		lo, hi := i-context, i+context+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(lines) {
+			hi = len(lines)
+		}
+
+		// Merge with the current hunk if the context regions touch,
+		// otherwise close it off and start a new one.
+		if start >= 0 && lo <= end {
+			end = hi
+			continue
+		}
+		if start >= 0 {
+			hunks = append(hunks, newHunk(lines[start:end]))
+		}
+		start, end = lo, hi
+	}
+	if start >= 0 {
+		hunks = append(hunks, newHunk(lines[start:end]))
+	}
+
+	return hunks
+}
+
+// Changed reports whether lines contains any insertions or deletions.
+func Changed(lines []Line) bool {
+	for _, l := range lines {
+		if l.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+func newHunk(lines []Line) Hunk {
+	h := Hunk{Lines: lines}
+
+	for _, l := range lines {
+		if l.OldNum != 0 {
+			if h.OldStart == 0 {
+				h.OldStart = l.OldNum
+			}
+			h.OldLines++
+		}
+		if l.NewNum != 0 {
+			if h.NewStart == 0 {
+				h.NewStart = l.NewNum
+			}
+			h.NewLines++
+		}
+	}
+
+	return h
+}
+
+// split breaks s into lines, normalizing Windows line endings and ignoring a
+// single trailing newline.
+func split(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// myers returns the shortest edit script that turns a into b. Rather than
+// keeping a copy of every round of the search so that it can walk back
+// through the edit graph, which takes O(D·(N+M)) space, it uses the linear
+// space refinement from Myers' paper: find the middle snake of the shortest
+// path by searching from both ends at once, then recurse on either side of
+// it. The only memory needed is the two V arrays, which are shared by every
+// level of the recursion.
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replaceAll(n, m)
+	}
+
+	s := &snakeSearch{
+		offset: n + m + 1,
+		vf:     make([]int, 2*(n+m)+3),
+		vb:     make([]int, 2*(n+m)+3),
+	}
+
+	// Give up if the texts are too different. It's enough to check the
+	// edit distance once, at the top, since every level of the recursion
+	// has fewer edits.
+	d, _, _, _, _, ok := s.middleSnake(a, b, (maxEdits+1)/2)
+	if !ok || d > maxEdits {
+		return replaceAll(n, m)
+	}
+
+	return s.diff(a, b, make([]Op, 0, n+m))
+}
+
+// snakeSearch holds the V arrays for the forward and backward searches,
+// indexed by diagonal k at vf[offset+k] and vb[offset+k].
+type snakeSearch struct {
+	offset int
+	vf, vb []int
+}
+
+// diff appends the shortest edit script that turns a into b to ops.
+func (s *snakeSearch) diff(a, b []string, ops []Op) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, Equal)
+	}
+
+	// With no common prefix or suffix, there are always at least two edits
+	// when neither side is empty, so each half of the split below has fewer
+	// edits than the whole and the recursion ends.
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(a) == 0 || len(b) == 0 {
+		ops = append(ops, replaceAll(len(a), len(b))...)
+	} else {
+		_, x0, y0, x1, y1, _ := s.middleSnake(a, b, (len(a)+len(b)+1)/2)
+
+		ops = s.diff(a[:x0], b[:y0], ops)
+		for i := x0; i < x1; i++ {
+			ops = append(ops, Equal)
+		}
+		ops = s.diff(a[x1:], b[y1:], ops)
+	}
+
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, Equal)
+	}
+
+	return ops
+}
+
+// middleSnake finds the middle snake of a shortest path from the start of a
+// and b to the end, by searching forwards from the start and backwards from
+// the end until the two searches overlap. It returns the length of the whole
+// path, and the snake as running from (x0, y0) to (x1, y1). If the searches
+// haven't met after limit rounds each, ok is false.
+func (s *snakeSearch) middleSnake(a, b []string, limit int) (d, x0, y0, x1, y1 int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	vf, vb, offset := s.vf, s.vb, s.offset
+
+	// The backward search works in the same way as the forward one, but
+	// with x and y counted from the end of the texts. Its diagonal c
+	// matches the forward diagonal delta-c.
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+
+			// When delta is odd, the paths can first meet after a
+			// forward round, against the previous backward round.
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+vb[offset+c] >= n {
+				return 2*d - 1, startX, startY, x, y, true
+			}
+		}
+
+		for c := -d; c <= d; c += 2 {
+			var x int
+			if c == -d || (c != d && vb[offset+c-1] < vb[offset+c+1]) {
+				x = vb[offset+c+1]
+			} else {
+				x = vb[offset+c-1] + 1
+			}
+			y := x - c
+
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			vb[offset+c] = x
+
+			if k := delta - c; !odd && k >= -d && k <= d && vf[offset+k]+x >= n {
+				return 2 * d, n - x, m - y, n - startX, m - startY, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, 0, false
+}
+
+func replaceAll(n, m int) []Op {
+	ops := make([]Op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, Delete)
+	}
+	for i := 0; i < m; i++ {
+		ops = append(ops, Insert)
+	}
+	return ops
+}
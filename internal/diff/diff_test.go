@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// apply rebuilds both texts from the output of Lines, and counts the edits.
+func apply(lines []Line) (a, b []string, edits int) {
+	for _, l := range lines {
+		switch l.Op {
+		case Equal:
+			a = append(a, l.Text)
+			b = append(b, l.Text)
+		case Delete:
+			a = append(a, l.Text)
+			edits++
+		case Insert:
+			b = append(b, l.Text)
+			edits++
+		}
+	}
+	return a, b, edits
+}
+
+// editDistance returns the number of insertions and deletions in the
+// shortest edit script, using the textbook LCS table.
+func editDistance(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] > lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "Equal",
+			a:    "a\nb\nc\n",
+			b:    "a\nb\nc",
+			want: "=a =b =c",
+		},
+		{
+			name: "Empty old",
+			a:    "",
+			b:    "a\nb",
+			want: "+a +b",
+		},
+		{
+			name: "Empty new",
+			a:    "a\nb",
+			b:    "",
+			want: "-a -b",
+		},
+		{
+			name: "Changed line",
+			a:    "a\nb\nc",
+			b:    "a\nx\nc",
+			want: "=a -b +x =c",
+		},
+		{
+			name: "Insert and delete",
+			a:    "a\nb\nc\nd",
+			b:    "b\nc\nx\nd",
+			want: "-a =b =c +x =d",
+		},
+		{
+			name: "Windows line endings",
+			a:    "a\r\nb\r\n",
+			b:    "a\nb\n",
+			want: "=a =b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, l := range Lines(tt.a, tt.b) {
+				got = append(got, map[Op]string{Equal: "=", Insert: "+", Delete: "-"}[l.Op]+l.Text)
+			}
+
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("got %q; want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
+
+func TestLinesShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	random := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		x, y := random(), random()
+
+		a, b, edits := apply(Lines(strings.Join(x, "\n"), strings.Join(y, "\n")))
+		if strings.Join(a, "\n") != strings.Join(x, "\n") || strings.Join(b, "\n") != strings.Join(y, "\n") {
+			t.Fatalf("diff of %q and %q doesn't rebuild them: got %q and %q", x, y, a, b)
+		}
+
+		if want := editDistance(x, y); edits != want {
+			t.Fatalf("diff of %q and %q has %d edits; want %d", x, y, edits, want)
+		}
+	}
+}
+
+func TestLinesLarge(t *testing.T) {
+	const n = 16000
+
+	same := make([]string, n)
+	other := make([]string, n)
+	for i := range same {
+		same[i] = fmt.Sprintf("line %d", i)
+		other[i] = fmt.Sprintf("other %d", i)
+	}
+
+	// Change every 20th line, which is within the edit budget.
+	changed := make([]string, n)
+	copy(changed, same)
+	for i := 0; i < n; i += 20 {
+		changed[i] = fmt.Sprintf("changed %d", i)
+	}
+
+	tests := []struct {
+		name  string
+		a     []string
+		b     []string
+		edits int
+	}{
+		{
+			name:  "Completely different",
+			a:     same,
+			b:     other,
+			edits: 2 * n,
+		},
+		{
+			name:  "Scattered changes",
+			a:     same,
+			b:     changed,
+			edits: 2 * n / 20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Join(tt.a, "\n"), strings.Join(tt.b, "\n")
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			lines := Lines(a, b)
+			runtime.ReadMemStats(&after)
+
+			_, _, edits := apply(lines)
+			if edits != tt.edits {
+				t.Errorf("got %d edits; want %d", edits, tt.edits)
+			}
+
+			// The lines themselves take about 1.5MB, and the search
+			// should only need a few times the size of the input on
+			// top of that.
+			const limit = 16 << 20
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > limit {
+				t.Errorf("allocated %d bytes; want at most %d", allocated, limit)
+			}
+		})
+	}
+}
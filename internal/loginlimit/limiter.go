@@ -0,0 +1,136 @@
+// Package loginlimit slows down password guessing by keeping count of failed
+// logins for each key, such as an email address or an IP address.
+//
+// After a few free failures, each further attempt has to wait for a delay
+// which doubles with every failure. After more failures the key is locked out
+// entirely for a while. Failures are forgotten after a quiet period, or when
+// the key is reset after a successful login. Each attempt is counted as a
+// failure before it's made, and taken back if it succeeds, so that guesses
+// made in parallel are limited too.
+//
+// The counts are kept in a Store. MemoryStore keeps them in the process,
+// which is fine for a single server, and models.LoginFailureModel keeps them
+// in MySQL so that they're shared between servers and survive restarts.
+package loginlimit
+
+import (
+	"time"
+)
+
+// Store keeps the number of consecutive failures for each key, and the time
+// of the most recent one.
+type Store interface {
+	// Attempt looks up the failures for key, counting from scratch if the
+	// last one was before since, and passes them to wait. If wait returns
+	// 0 then a failure is recorded for key at now, before Attempt returns,
+	// and the count including it is returned. Otherwise nothing is
+	// recorded and the wait is returned. Looking up the failures and
+	// recording the new one must happen atomically, so that concurrent
+	// attempts for the same key are counted one after another.
+	Attempt(key string, now, since time.Time, wait func(failures int, last time.Time) time.Duration) (int, time.Duration, error)
+	// Release takes back one failure recorded for key by Attempt.
+	Release(key string) error
+	// Reset forgets all of the failures for key.
+	Reset(key string) error
+	// Prune forgets every key whose most recent failure was before before.
+	Prune(before time.Time) error
+}
+
+// Policy says how long a key has to wait after a number of failures.
+type Policy struct {
+	// Free is how many failures are allowed before any delay.
+	Free int
+	// Delay is the wait after the first failure past Free. It doubles with
+	// every failure after that, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// LockoutAfter is the number of failures which locks the key out for
+	// Lockout.
+	LockoutAfter int
+	Lockout      time.Duration
+	// Forget is how long after the last failure all the failures are
+	// forgotten.
+	Forget time.Duration
+}
+
+// Wait returns how long a key has to wait after its last failure, once it
+// has failed the given number of times.
+func (p Policy) Wait(failures int) time.Duration {
+	switch {
+	case failures >= p.LockoutAfter:
+		return p.Lockout
+	case failures <= p.Free:
+		return 0
+	}
+
+	delay := p.Delay
+	for i := p.Free + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay
+}
+
+// A Limiter applies a Policy to the failures kept in a Store. The same Store
+// can be shared by limiters with different policies, as long as they use
+// different keys.
+type Limiter struct {
+	Store  Store
+	Policy Policy
+}
+
+// A Reservation is the result of Limiter.Attempt.
+type Reservation struct {
+	// Wait is how much longer the key has to wait before it can try again.
+	// If it's more than 0 the attempt was refused.
+	Wait time.Duration
+	// Locked reports whether the attempt locks the key out if it fails, so
+	// that the owner can be told about it once rather than on every failure
+	// afterwards.
+	Locked bool
+}
+
+// Attempt checks whether key can try now, and if it can, counts the attempt
+// as a failure straight away. Counting it before the attempt is made, rather
+// than after it fails, means that attempts made in parallel can't all get
+// past the check before any of them have been counted.
+//
+// If the attempt succeeds, the caller should take it back with Release, or
+// forget all of the failures for the key with Reset.
+func (l *Limiter) Attempt(key string) (Reservation, error) {
+	now := time.Now()
+
+	wait := func(failures int, last time.Time) time.Duration {
+		if failures == 0 {
+			return 0
+		}
+
+		wait := last.Add(l.Policy.Wait(failures)).Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+
+		return wait
+	}
+
+	failures, w, err := l.Store.Attempt(key, now, now.Add(-l.Policy.Forget), wait)
+	if err != nil || w > 0 {
+		return Reservation{Wait: w}, err
+	}
+
+	return Reservation{Locked: failures == l.Policy.LockoutAfter}, nil
+}
+
+// Release takes back an attempt counted by Attempt, after it has succeeded.
+func (l *Limiter) Release(key string) error {
+	return l.Store.Release(key)
+}
+
+// Reset forgets the failures for key, after it has been used successfully.
+func (l *Limiter) Reset(key string) error {
+	return l.Store.Reset(key)
+}
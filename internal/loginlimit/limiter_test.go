@@ -0,0 +1,346 @@
+package loginlimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyWait(t *testing.T) {
+	policy := Policy{
+		Free:         3,
+		Delay:        time.Second,
+		MaxDelay:     10 * time.Second,
+		LockoutAfter: 10,
+		Lockout:      time.Hour,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, time.Hour},
+		{50, time.Hour},
+	}
+
+	for _, tt := range tests {
+		got := policy.Wait(tt.failures)
+		if got != tt.want {
+			t.Errorf("Wait(%d) = %s; want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// noWait is a wait function for MemoryStore.Attempt which always lets the
+// attempt through.
+func noWait(int, time.Time) time.Duration {
+	return 0
+}
+
+func TestMemoryStore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		run  func(s *MemoryStore) int
+		want int
+	}{
+		{
+			name: "Attempts are counted",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("a", start, start, noWait)
+				failures, _, _ := s.Attempt("a", start, start, noWait)
+				return failures
+			},
+			want: 3,
+		},
+		{
+			name: "Keys are counted separately",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				failures, _, _ := s.Attempt("b", start, start, noWait)
+				return failures
+			},
+			want: 1,
+		},
+		{
+			name: "Old failures are forgotten",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("a", start, start, noWait)
+				later := start.Add(time.Hour)
+				failures, _, _ := s.Attempt("a", later, later.Add(-time.Minute), noWait)
+				return failures
+			},
+			want: 1,
+		},
+		{
+			name: "Refused attempts aren't counted",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("a", start, start, func(int, time.Time) time.Duration { return time.Minute })
+				failures, _, _ := s.Attempt("a", start, start, noWait)
+				return failures
+			},
+			want: 2,
+		},
+		{
+			name: "Release takes back one attempt",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("a", start, start, noWait)
+				s.Release("a")
+				failures, _, _ := s.Attempt("a", start, start, noWait)
+				return failures
+			},
+			want: 2,
+		},
+		{
+			name: "Release doesn't go below zero",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Release("a")
+				s.Release("a")
+				s.Release("b")
+				failures, _, _ := s.Attempt("a", start, start, noWait)
+				return failures
+			},
+			want: 1,
+		},
+		{
+			name: "Reset forgets every attempt",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("a", start, start, noWait)
+				s.Reset("a")
+				failures, _, _ := s.Attempt("a", start, start, noWait)
+				return failures
+			},
+			want: 1,
+		},
+		{
+			name: "Prune forgets old keys",
+			run: func(s *MemoryStore) int {
+				s.Attempt("a", start, start, noWait)
+				s.Attempt("b", start.Add(time.Hour), start, noWait)
+				s.Prune(start.Add(time.Minute))
+				return len(s.entries)
+			},
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.run(NewMemoryStore())
+			if got != tt.want {
+				t.Errorf("got %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreAttemptWait(t *testing.T) {
+	s := NewMemoryStore()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	s.Attempt("a", start, start, noWait)
+	s.Attempt("a", start.Add(time.Second), start, noWait)
+
+	var gotFailures int
+	var gotLast time.Time
+	failures, wait, err := s.Attempt("a", start.Add(2*time.Second), start, func(failures int, last time.Time) time.Duration {
+		gotFailures, gotLast = failures, last
+		return time.Minute
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotFailures != 2 || !gotLast.Equal(start.Add(time.Second)) {
+		t.Errorf("wait was given (%d, %s); want (2, %s)", gotFailures, gotLast, start.Add(time.Second))
+	}
+	if failures != 2 || wait != time.Minute {
+		t.Errorf("got (%d, %s); want (2, 1m0s)", failures, wait)
+	}
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	l := &Limiter{
+		Store: NewMemoryStore(),
+		Policy: Policy{
+			Free:         2,
+			Delay:        time.Hour,
+			MaxDelay:     time.Hour,
+			LockoutAfter: 4,
+			Lockout:      24 * time.Hour,
+			Forget:       48 * time.Hour,
+		},
+	}
+
+	// The first three attempts go straight through, since the third is
+	// checked against only two failures. The fourth has to wait for the
+	// delay after the third failure.
+	tests := []struct {
+		name       string
+		minWait    time.Duration
+		maxWait    time.Duration
+		wantLocked bool
+	}{
+		{name: "First"},
+		{name: "Second"},
+		{name: "Third"},
+		{name: "Delayed", minWait: 59 * time.Minute, maxWait: time.Hour},
+		{name: "Still delayed", minWait: 59 * time.Minute, maxWait: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := l.Attempt("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Wait < tt.minWait || r.Wait > tt.maxWait {
+				t.Errorf("got wait %s; want between %s and %s", r.Wait, tt.minWait, tt.maxWait)
+			}
+			if r.Locked != tt.wantLocked {
+				t.Errorf("got locked %t; want %t", r.Locked, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	l := &Limiter{
+		Store: NewMemoryStore(),
+		Policy: Policy{
+			Free:         3,
+			LockoutAfter: 3,
+			Lockout:      time.Hour,
+			Forget:       24 * time.Hour,
+		},
+	}
+
+	// Only the attempt which reaches the threshold reports the lockout, so
+	// that the owner is told once.
+	tests := []struct {
+		name       string
+		wantWait   bool
+		wantLocked bool
+	}{
+		{name: "First"},
+		{name: "Second"},
+		{name: "Reaches the threshold", wantLocked: true},
+		{name: "Locked out", wantWait: true},
+		{name: "Still locked out", wantWait: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := l.Attempt("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (r.Wait > 0) != tt.wantWait {
+				t.Errorf("got wait %s; want a wait: %t", r.Wait, tt.wantWait)
+			}
+			if r.Locked != tt.wantLocked {
+				t.Errorf("got locked %t; want %t", r.Locked, tt.wantLocked)
+			}
+		})
+	}
+
+	// A successful login lets the key try again straight away.
+	err := l.Reset("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := l.Attempt("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Wait != 0 || r.Locked {
+		t.Errorf("after Reset got %+v; want no wait and not locked", r)
+	}
+}
+
+func TestLimiterRelease(t *testing.T) {
+	l := &Limiter{
+		Store: NewMemoryStore(),
+		Policy: Policy{
+			Free:         1,
+			Delay:        time.Hour,
+			MaxDelay:     time.Hour,
+			LockoutAfter: 10,
+			Lockout:      time.Hour,
+			Forget:       24 * time.Hour,
+		},
+	}
+
+	// Attempts which succeed are taken back, so they never add up to a
+	// delay.
+	for i := 0; i < 5; i++ {
+		r, err := l.Attempt("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Wait != 0 {
+			t.Fatalf("attempt %d: got wait %s; want none", i+1, r.Wait)
+		}
+
+		err = l.Release("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLimiterAttemptConcurrent(t *testing.T) {
+	l := &Limiter{
+		Store: NewMemoryStore(),
+		Policy: Policy{
+			Free:         3,
+			Delay:        time.Hour,
+			MaxDelay:     time.Hour,
+			LockoutAfter: 10,
+			Lockout:      time.Hour,
+			Forget:       24 * time.Hour,
+		},
+	}
+
+	// Attempts are counted as they're let through, so however many are
+	// made at once, only the free ones and the first delayed one get
+	// through.
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := l.Attempt("a")
+			if err == nil && r.Wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 4 {
+		t.Errorf("got %d attempts through; want 4", got)
+	}
+}
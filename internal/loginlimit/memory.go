@@ -0,0 +1,75 @@
+package loginlimit
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	failures int
+	last     time.Time
+}
+
+// MemoryStore is a Store which keeps everything in memory. It's safe for
+// concurrent use, but isn't shared between servers and is lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Attempt(key string, now, since time.Time, wait func(int, time.Time) time.Duration) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.last.Before(since) {
+		e = &memoryEntry{}
+	}
+
+	if w := wait(e.failures, e.last); w > 0 {
+		return e.failures, w, nil
+	}
+
+	e.failures++
+	e.last = now
+	s.entries[key] = e
+
+	return e.failures, 0, nil
+}
+
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.failures > 0 {
+		e.failures--
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, e := range s.entries {
+		if e.last.Before(before) {
+			delete(s.entries, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package mailer sends plain text emails, such as password reset links.
+//
+// The Mailer interface has an SMTP implementation for production, and a Log
+// implementation which just writes each message to a logger, for local
+// development and tests.
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// A Message is a plain text email to a single recipient.
+type Message struct {
+	To string
+	Subject string
+	Body string
+}
+
+// A Mailer delivers messages.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// errHeaderInjection is returned if a message's recipient or subject contain
+// line breaks, which could be used to add extra headers.
+var errHeaderInjection = errors.New("mailer: line break in message header")
+
+// SMTP sends messages through an SMTP server. Addr is the server's host and
+// port, and Auth may be nil if the server doesn't need authentication.
+type SMTP struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+}
+
+// NewSMTP returns an SMTP mailer for the server at host:port, using PLAIN
+// authentication if a username is given.
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	m := &SMTP{
+		Addr: fmt.Sprintf("%s:%d", host, port),
+		From: from,
+	}
+	if username != "" {
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+// Send delivers a message through the SMTP server.
+func (m *SMTP) Send(msg Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	data, err := format(from.String(), msg)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.Addr, m.Auth, from.Address, []string{msg.To}, data)
+}
+
+// format builds the RFC 5322 form of a message, with the body encoded as
+// quoted-printable so that it can hold any UTF-8 text.
+func format(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errHeaderInjection
+	}
+
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	_, err := qp.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+
+	err = qp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Log writes messages to a logger instead of sending them, so that links in
+// them can be followed without a mail server.
+type Log struct {
+	Logger *log.Logger
+}
+
+// Send writes the message to the logger.
+func (m *Log) Send(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errHeaderInjection
+	}
+
+	m.Logger.Printf("Email to %s\nSubject: %s\n\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
@@ -0,0 +1,67 @@
+// Package markdown renders snippets written in Markdown as HTML.
+//
+// The output is sanitized, so it is safe to include in a page even when the
+// Markdown came from an untrusted user. Fenced code blocks are syntax
+// highlighted with the same CSS classes as the highlight package, so they
+// pick up the current theme's stylesheet.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+)
+
+// converter turns Markdown into HTML. GFM adds tables, strikethrough,
+// autolinks and task lists to CommonMark. Raw HTML in the Markdown is left
+// out, since goldmark only passes it through when the unsafe option is set.
+var converter = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithFormatOptions(
+				chromahtml.WithClasses(true),
+				chromahtml.TabWidth(4),
+			),
+		),
+	),
+)
+
+// classRX matches the class attributes produced by the highlighter, which
+// are lists of short class names.
+var classRX = regexp.MustCompile(`^[a-zA-Z0-9 _-]+$`)
+
+// policy is the sanitizer applied to the HTML from the converter, as a second
+// line of defence in case anything unsafe gets through. It's based on
+// bluemonday's policy for user generated content, which never allows
+// scripts, event handlers or style attributes, so nothing it lets through
+// needs the Content-Security-Policy to be relaxed. On top of that we allow
+// the class attributes used for syntax highlighting and task list
+// checkboxes.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(classRX).OnElements("pre", "code", "span", "div")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// Render converts Markdown source into sanitized HTML.
+func Render(source string) (string, error) {
+	var buf bytes.Buffer
+
+	err := converter.Convert([]byte(source), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return policy.Sanitize(buf.String()), nil
+}
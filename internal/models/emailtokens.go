@@ -0,0 +1,107 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The purposes an email token can be issued for.
+const (
+	PurposePasswordReset = "password-reset"
+	PurposeEmailVerification = "email-verification"
+)
+
+// Define an EmailTokenModel type which wraps a database connection pool. It
+// manages the single-use tokens which are emailed to users as links.
+type EmailTokenModel struct {
+	DB *sql.DB
+}
+
+// This will create a new token for a user which can be used once for the
+// given purpose within ttl, returning the token itself. Only its hash is
+// stored.
+func (m *EmailTokenModel) New(userID int, purpose string, ttl time.Duration) (string, error) {
+	plaintext, hash, err := newToken("")
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO email_tokens (token_hash, user_id, purpose, created, expires)
+	VALUES(?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	_, err = m.DB.Exec(stmt, hash, userID, purpose, time.Now().UTC().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// This will use up a token, returning the ID of the user it was issued to.
+// If the token doesn't exist, has expired, has already been used or was
+// issued for a different purpose then ErrNoRecord is returned.
+//
+// The token is deleted in the same transaction as it's read, and the SELECT
+// locks the row, so a token can only ever be used once even if it's
+// submitted twice at the same moment.
+func (m *EmailTokenModel) Consume(plaintext, purpose string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	hash := hashToken(plaintext)
+
+	stmt := `SELECT user_id FROM email_tokens
+	WHERE token_hash = ? AND purpose = ? AND expires > UTC_TIMESTAMP()
+	FOR UPDATE`
+
+	var userID int
+
+	err = tx.QueryRow(stmt, hash, purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec("DELETE FROM email_tokens WHERE token_hash = ?", hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// This will delete all of a user's tokens for a purpose, so that any other
+// links they've been sent stop working.
+func (m *EmailTokenModel) DeleteAll(userID int, purpose string) error {
+	_, err := m.DB.Exec("DELETE FROM email_tokens WHERE user_id = ? AND purpose = ?", userID, purpose)
+	return err
+}
+
+// This will return the time that the most recent unexpired token for a
+// purpose was issued to a user, or the zero time if there isn't one. It's
+// used to stop users from asking for lots of emails in a short time.
+func (m *EmailTokenModel) LastIssued(userID int, purpose string) (time.Time, error) {
+	var t time.Time
+
+	stmt := `SELECT MAX(created) FROM email_tokens
+	WHERE user_id = ? AND purpose = ? AND expires > UTC_TIMESTAMP()`
+
+	err := m.DB.QueryRow(stmt, userID, purpose).Scan(nullTime{&t})
+	return t, err
+}
+
+// This will delete every expired token, returning the number deleted.
+func (m *EmailTokenModel) DeleteExpired() (int64, error) {
+	result, err := m.DB.Exec("DELETE FROM email_tokens WHERE expires <= UTC_TIMESTAMP()")
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The reasons a login can fail, as recorded in the audit log.
+const (
+	LoginFailurePassword  = "password"
+	LoginFailureCode      = "2fa"
+	LoginFailureThrottled = "throttled"
+)
+
+// Define a LoginFailureModel type which wraps a database connection pool. It
+// keeps the counts of failed logins used by the login limiter, and satisfies
+// the loginlimit.Store interface. Keys are stored as SHA-256 hashes, so that
+// they fit in the primary key however long the email address in them is.
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// This will look up the failed logins for key and, if wait returns 0 for
+// them, record another one straight away. The row is locked while this
+// happens, so that concurrent logins for the same key are counted one after
+// another. Failures before since are ignored.
+func (m *LoginFailureModel) Attempt(key string, now, since time.Time, wait func(int, time.Time) time.Duration) (int, time.Duration, error) {
+	hash := hashToken(key)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Make sure there's a row to lock, even for the first failure.
+	stmt := `INSERT INTO login_failures (key_hash, failures, last_failure)
+	VALUES(?, 0, ?)
+	ON DUPLICATE KEY UPDATE key_hash = key_hash`
+
+	_, err = tx.Exec(stmt, hash, now.UTC())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var failures int
+	var last time.Time
+
+	stmt = "SELECT failures, last_failure FROM login_failures WHERE key_hash = ? FOR UPDATE"
+
+	err = tx.QueryRow(stmt, hash).Scan(&failures, &last)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if last.Before(since) {
+		failures = 0
+	}
+
+	if w := wait(failures, last); w > 0 {
+		return failures, w, tx.Commit()
+	}
+
+	failures++
+
+	stmt = "UPDATE login_failures SET failures = ?, last_failure = ? WHERE key_hash = ?"
+
+	_, err = tx.Exec(stmt, failures, now.UTC(), hash)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return failures, 0, tx.Commit()
+}
+
+// This will take back one failed login recorded for key.
+func (m *LoginFailureModel) Release(key string) error {
+	stmt := "UPDATE login_failures SET failures = GREATEST(failures - 1, 0) WHERE key_hash = ?"
+
+	_, err := m.DB.Exec(stmt, hashToken(key))
+	return err
+}
+
+// This will forget the failed logins for key.
+func (m *LoginFailureModel) Reset(key string) error {
+	_, err := m.DB.Exec("DELETE FROM login_failures WHERE key_hash = ?", hashToken(key))
+	return err
+}
+
+// This will forget every key whose last failed login was before before.
+func (m *LoginFailureModel) Prune(before time.Time) error {
+	_, err := m.DB.Exec("DELETE FROM login_failures WHERE last_failure < ?", before.UTC())
+	return err
+}
+
+// Define a LoginAuditModel type which wraps a database connection pool. It
+// keeps a record of every failed login.
+type LoginAuditModel struct {
+	DB *sql.DB
+}
+
+// This will record a failed login for an email address. If a user has that
+// address, the record is linked to them.
+func (m *LoginAuditModel) Insert(email, ip, userAgent, reason string) error {
+	// The user agent comes straight from the client, so make sure it fits
+	// in the column and is valid UTF-8.
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	userAgent = strings.ToValidUTF8(userAgent, "")
+
+	stmt := `INSERT INTO login_audit (email, user_id, ip, user_agent, reason, created)
+	VALUES(?, (SELECT id FROM users WHERE email = ?), ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, email, email, ip, userAgent, reason)
+	return err
+}
+
+// This will delete audit records older than retention, returning the number
+// deleted.
+func (m *LoginAuditModel) DeleteOlder(retention time.Duration) (int64, error) {
+	stmt := "DELETE FROM login_audit WHERE created < ?"
+
+	result, err := m.DB.Exec(stmt, time.Now().UTC().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
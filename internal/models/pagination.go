@@ -0,0 +1,92 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The columns that SnippetModel.List can sort by.
+const (
+	SortCreated = "created"
+	SortExpires = "expires"
+)
+
+// neverExpires stands in for the expiry time of snippets which never expire
+// when sorting by expiry, so that they come after every other snippet.
+var neverExpires = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+// ErrInvalidCursor is returned by DecodeCursor if a cursor string has been
+// tampered with or is otherwise malformed.
+var ErrInvalidCursor = errors.New("models: invalid cursor")
+
+// A Cursor marks a position in a sorted list of snippets, so that the next
+// or previous page can be fetched with a keyset query rather than an OFFSET.
+// It holds the value of the sort column for a snippet, plus its slug to break
+// ties between snippets with the same value.
+type Cursor struct {
+	Time time.Time
+	Slug string
+}
+
+// Encode returns the cursor as an opaque, URL-safe string.
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + "|" + c.Slug
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a string produced by Cursor.Encode.
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	nanos, slug, ok := strings.Cut(string(raw), "|")
+	if !ok || len(slug) != slugLength {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{Time: time.Unix(0, n).UTC(), Slug: slug}, nil
+}
+
+// ListOptions controls which page of snippets SnippetModel.List returns. At
+// most one of After and Before should be set; if neither is, the first page
+// is returned. If Tag is set, only snippets with that tag are listed.
+type ListOptions struct {
+	Sort string
+	Descending bool
+	PageSize int
+	After *Cursor
+	Before *Cursor
+	Tag string
+}
+
+// A Page is one page of results from SnippetModel.List. Next and Prev are nil
+// when there are no more snippets in that direction.
+type Page struct {
+	Snippets []*Snippet
+	Next *Cursor
+	Prev *Cursor
+}
+
+// cursorFor returns the cursor pointing at s in a list sorted by the given
+// column.
+func cursorFor(s *Snippet, sort string) *Cursor {
+	t := s.Created
+	if sort == SortExpires {
+		t = s.Expires
+		if t.IsZero() {
+			t = neverExpires
+		}
+	}
+
+	return &Cursor{Time: t.UTC(), Slug: s.Slug}
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a Revision type to hold a single saved version of a snippet.
+// Revisions are written by SnippetModel whenever a snippet is created or
+// edited, so the latest revision always matches the snippet itself.
+type Revision struct {
+	ID int
+	SnippetID int
+	Version int
+	Title string
+	Content string
+	Created time.Time
+	// UserID and UserName identify the user who saved this version.
+	UserID int
+	UserName string
+}
+
+// Define a RevisionModel type which wraps a sql.DB connection pool.
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// This will return all revisions of a snippet, newest first.
+func (m *RevisionModel) All(snippetID int) ([]*Revision, error) {
+	stmt := `SELECT r.id, r.snippet_id, r.version, r.title, r.content, r.created,
+	COALESCE(r.user_id, 0), COALESCE(u.name, '')
+	FROM snippet_revisions r LEFT JOIN users u ON u.id = r.user_id
+	WHERE r.snippet_id = ? ORDER BY r.version DESC`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+
+	for rows.Next() {
+		r := &Revision{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.Version, &r.Title, &r.Content, &r.Created, &r.UserID, &r.UserName)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// This will return a specific version of a snippet.
+func (m *RevisionModel) Get(snippetID, version int) (*Revision, error) {
+	stmt := `SELECT r.id, r.snippet_id, r.version, r.title, r.content, r.created,
+	COALESCE(r.user_id, 0), COALESCE(u.name, '')
+	FROM snippet_revisions r LEFT JOIN users u ON u.id = r.user_id
+	WHERE r.snippet_id = ? AND r.version = ?`
+
+	r := &Revision{}
+
+	err := m.DB.QueryRow(stmt, snippetID, version).Scan(&r.ID, &r.SnippetID, &r.Version, &r.Title, &r.Content, &r.Created, &r.UserID, &r.UserName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// insertRevision copies the current state of a snippet into the
+// snippet_revisions table as its next version. It is called from within the
+// SnippetModel transactions which create and update snippets; the preceding
+// write to the snippets row holds a lock on it, so concurrent edits can't
+// claim the same version number.
+func insertRevision(tx *sql.Tx, snippetID, userID int) error {
+	stmt := `INSERT INTO snippet_revisions (snippet_id, version, user_id, title, content, created)
+	SELECT s.id,
+	(SELECT COALESCE(MAX(r.version), 0) + 1 FROM snippet_revisions r WHERE r.snippet_id = s.id),
+	NULLIF(?, 0), s.title, s.content, UTC_TIMESTAMP()
+	FROM snippets s WHERE s.id = ?`
+
+	_, err := tx.Exec(stmt, userID, snippetID)
+	return err
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchQuery is a search string broken down into individual terms and
+// quoted phrases. Every term and phrase must appear in a snippet for it to
+// match.
+type SearchQuery struct {
+	Terms []string
+	Phrases []string
+}
+
+// minTermLength matches InnoDB's default innodb_ft_min_token_size. Shorter
+// words aren't in the full-text index, so requiring them would mean nothing
+// ever matched.
+const minTermLength = 3
+
+// ParseSearchQuery parses a search string as typed by a user. Anything in
+// double quotes is treated as a phrase, and everything else is split into
+// terms. Punctuation is discarded, so that users can't inject MySQL boolean
+// mode operators.
+func ParseSearchQuery(s string) SearchQuery {
+	var q SearchQuery
+
+	// Splitting on double quotes means every odd-numbered part was inside a
+	// pair of quotes. An unmatched quote just quotes the rest of the string.
+	for i, part := range strings.Split(s, `"`) {
+		words := searchWords(part)
+		if len(words) == 0 {
+			continue
+		}
+
+		if i%2 == 1 && len(words) > 1 {
+			q.Phrases = append(q.Phrases, strings.Join(words, " "))
+			continue
+		}
+
+		for _, word := range words {
+			if utf8.RuneCountInString(word) >= minTermLength {
+				q.Terms = append(q.Terms, word)
+			}
+		}
+	}
+
+	return q
+}
+
+// Empty reports whether the query has nothing to search for.
+func (q SearchQuery) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// booleanMode returns the query as a MATCH() ... AGAINST() expression for
+// use IN BOOLEAN MODE, with every term and phrase required.
+func (q SearchQuery) booleanMode() string {
+	var parts []string
+
+	for _, term := range q.Terms {
+		parts = append(parts, "+"+term)
+	}
+	for _, phrase := range q.Phrases {
+		parts = append(parts, `+"`+phrase+`"`)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// searchWords splits s into lower-case words made up of letters, digits and
+// underscores.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+// slugLength is the number of characters in a snippet slug. Twelve base62
+// characters gives a little over 71 bits of randomness, which is plenty to
+// make slugs impractical to guess.
+const slugLength = 12
+
+const slugAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// newSlug returns a random base62 string for use as a snippet identifier.
+func newSlug() (string, error) {
+	b := make([]byte, slugLength)
+	max := big.NewInt(int64(len(slugAlphabet)))
+
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = slugAlphabet[n.Int64()]
+	}
+
+	return string(b), nil
+}
@@ -3,7 +3,27 @@ package models
 import (
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The visibility levels a snippet can have. Public snippets are listed on
+// the home page, unlisted snippets can only be reached by someone who knows
+// their URL, and private snippets can only be seen by their owner.
+const (
+	VisibilityPublic = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate = "private"
+)
+
+// The formats a snippet can be displayed in. Text snippets are shown as
+// preformatted text, syntax highlighted according to their language, while
+// Markdown snippets are rendered as HTML.
+const (
+	FormatText = "text"
+	FormatMarkdown = "markdown"
 )
 
 // Define a Snippet type to hold the data for an individual snippet. Notice how
@@ -11,31 +31,159 @@ import (
 // table?
 type Snippet struct {
 	ID int
+	// Slug is the random identifier used to address the snippet in URLs. The
+	// sequential ID is never exposed to users.
+	Slug string
 	Title string
 	Content string
+	// Language is the language used to syntax highlight the content, as
+	// understood by the highlight package.
+	Language string
+	Format string
 	Created time.Time
+	// Expires is the zero time for snippets which never expire.
 	Expires time.Time
+	Visibility string
+	// Views is the number of times the snippet has been viewed by someone
+	// other than its owner. If MaxViews is greater than zero the snippet is
+	// deleted once Views reaches it.
+	Views int
+	MaxViews int
+	// UserID and UserName identify the user who created the snippet. Both
+	// are zero-valued for snippets created before ownership was recorded.
+	UserID int
+	UserName string
+	// Tags holds the snippet's tags in alphabetical order.
+	Tags []string
+	// Deleted is the time the snippet was moved to the trash. It is only
+	// populated by the Trash method.
+	Deleted time.Time
+}
+
+
+// VisibleTo reports whether the user with the given ID (or 0 for an anonymous
+// visitor) is allowed to see the snippet.
+func (s *Snippet) VisibleTo(userID int) bool {
+	if s.Visibility != VisibilityPrivate {
+		return true
+	}
+
+	return s.OwnedBy(userID)
+}
+
+// OwnedBy reports whether the snippet belongs to the user with the given ID.
+// Snippets created before ownership was recorded aren't owned by anyone.
+func (s *Snippet) OwnedBy(userID int) bool {
+	return s.UserID != 0 && s.UserID == userID
+}
+
+// LastView reports whether the snippet has used up all of its views, meaning
+// that it has now been deleted.
+func (s *Snippet) LastView() bool {
+	return s.MaxViews > 0 && s.Views >= s.MaxViews
+}
+
+// snippetColumns lists the columns returned by every query which selects
+// snippets, in the same order as the destinations returned by scanDest().
+// Queries using it must alias the snippets table as s and LEFT JOIN the users
+// table as u.
+const snippetColumns = `s.id, s.slug, s.title, s.content, s.language, s.format, s.created, s.expires, s.visibility,
+	s.views, COALESCE(s.max_views, 0), COALESCE(s.user_id, 0), COALESCE(u.name, ''),
+	(SELECT GROUP_CONCAT(t.name ORDER BY t.name SEPARATOR ',')
+		FROM snippet_tags st JOIN tags t ON t.id = st.tag_id WHERE st.snippet_id = s.id)`
+
+// scanDest returns pointers to the fields of the snippet in the order that
+// they appear in snippetColumns, ready to be passed to Scan().
+func (s *Snippet) scanDest() []any {
+	return []any{&s.ID, &s.Slug, &s.Title, &s.Content, &s.Language, &s.Format, &s.Created, nullTime{&s.Expires}, &s.Visibility, &s.Views, &s.MaxViews, &s.UserID, &s.UserName, tagList{&s.Tags}}
+}
+
+// nullTime scans a nullable DATETIME column into a time.Time, leaving it as
+// the zero time if the column is NULL.
+type nullTime struct {
+	t *time.Time
+}
+
+func (n nullTime) Scan(value any) error {
+	var nt sql.NullTime
+
+	err := nt.Scan(value)
+	if err != nil {
+		return err
+	}
+
+	*n.t = nt.Time
+	return nil
 }
 
+// expiresParam converts an expiry time into a value for a nullable DATETIME
+// placeholder, using NULL for the zero time.
+func expiresParam(expires time.Time) sql.NullTime {
+	return sql.NullTime{Time: expires.UTC(), Valid: !expires.IsZero()}
+}
+
+// SnippetIndexer is implemented by anything which needs to be told when the
+// searchable parts of a snippet change, such as an in-process search index.
+// RemoveSnippet is called when a snippet can no longer be found, whether it
+// was trashed, burned or permanently deleted.
+type SnippetIndexer interface {
+	IndexSnippet(s *Snippet)
+	RemoveSnippet(id int)
+}
+
+// SnippetModelInterface describes the methods of SnippetModel used by the web
+// application, so that it can be replaced with a stub in tests.
+type SnippetModelInterface interface {
+	Get(slug string) (*Snippet, error)
+	GetByID(id int) (*Snippet, error)
+	GetMany(ids []int) ([]*Snippet, error)
+	Latest() ([]*Snippet, error)
+	List(opts ListOptions) (*Page, error)
+	Search(q SearchQuery, userID int, offset, limit int) ([]*Snippet, error)
+	Insert(userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) (string, error)
+	Update(id int, userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) error
+	RecordView(id int) (*Snippet, error)
+	Delete(id int) error
+	Trash(userID int) ([]*Snippet, error)
+	Restore(slug string, userID int) error
+	Purge(slug string, userID int) error
+	PurgeTrashed(retention time.Duration) (int64, error)
+	DeleteExpired(batchSize int) (int64, error)
+}
 
-// Define a SnippetModel type which wraps a sql.DB connection pool
+// Define a SnippetModel type which wraps a sql.DB connection pool. If Indexer
+// is set it is kept up to date as snippets are inserted, updated and deleted.
 type SnippetModel struct { 
 	DB *sql.DB
+	Indexer SnippetIndexer
 }
 
+func (m *SnippetModel) index(s *Snippet) {
+	if m.Indexer != nil {
+		m.Indexer.IndexSnippet(s)
+	}
+}
+
+func (m *SnippetModel) unindex(id int) {
+	if m.Indexer != nil {
+		m.Indexer.RemoveSnippet(id)
+	}
+}
 
-// This will return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (*Snippet, error) { 
+
+// This will return a specific snippet based on its slug.
+func (m *SnippetModel) Get(slug string) (*Snippet, error) { 
 	// Write the SQL statement we want to execute. Again, I've split it over two 
 	// lines for readability.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL AND s.slug = ?`
 
 	// Use the QueryRow() method on the connection pool to execute our
-	// SQL statement, passing in the untrusted id variable as the value for the 
+	// SQL statement, passing in the untrusted slug variable as the value for the 
 	// placeholder parameter. This returns a pointer to a sql.Row object which 
 	// holds the result from the database.
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRow(stmt, slug)
 
 	// Initialize a pointer to a new zeroed Snippet struct.
 	s := &Snippet{}
@@ -45,7 +193,7 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	// to row.Scan are *pointers* to the place you want to copy the data into, 
 	// and the number of arguments must be exactly the same as the number of 
 	// columns returned by your statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(s.scanDest()...)
 	if err != nil {
 		// If the query returns no rows, then row.Scan() will return a
 		// sql.ErrNoRows error. We use the errors.Is() function check for that 
@@ -62,10 +210,114 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
-// This will return the 10 most recently created snippets.
+// This will return a specific snippet based on its numeric id. It only exists
+// so that links created before snippets had slugs can still be followed.
+func (m *SnippetModel) GetByID(id int) (*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL AND s.id = ?`
+
+	s := &Snippet{}
+
+	err := m.DB.QueryRow(stmt, id).Scan(s.scanDest()...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// This will return the snippets with the given ids, in the same order. Any
+// which have expired, been deleted or never existed are left out.
+func (m *SnippetModel) GetMany(ids []int) ([]*Snippet, error) {
+	if len(ids) == 0 {
+		return []*Snippet{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL
+	AND s.id IN (` + placeholders + `)`
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int]*Snippet, len(ids))
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(s.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		byID[s.ID] = s
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	snippets := []*Snippet{}
+	for _, id := range ids {
+		if s, ok := byID[id]; ok {
+			snippets = append(snippets, s)
+		}
+	}
+
+	return snippets, nil
+}
+
+// This will call fn for every snippet which hasn't expired or been deleted,
+// in id order, stopping at the first error. It's used to rebuild the search
+// index from scratch.
+func (m *SnippetModel) Each(fn func(*Snippet) error) error {
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL
+	ORDER BY s.id`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(s.scanDest()...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// This will return the 10 most recently created public snippets.
 func (m *SnippetModel) Latest() ([]*Snippet, error) { 
 	// Write the SQL statement we want to execute.
-	stmt := `SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL AND s.visibility = 'public'
+	ORDER BY s.id DESC LIMIT 10`
 	
 	// Use the Query() method on the connection pool to execute our
 	// SQL statement. This returns a sql.Rows resultset containing the result of 
@@ -98,7 +350,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 		// must be pointers to the place you want to copy the data into, and the 
 		// number of arguments must be exactly the same as the number of
 		// columns returned by your statement.
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(s.scanDest()...)
 		if err != nil {
 			return nil, err 
 		}
@@ -117,31 +369,519 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	return snippets, nil
 }
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) { 
+// This will return one page of public snippets, sorted and positioned as
+// described by opts. It uses keyset pagination: rather than skipping rows with
+// an OFFSET, each page starts from the sort value and slug of the last snippet
+// on the previous page, so fetching deep pages stays cheap.
+func (m *SnippetModel) List(opts ListOptions) (*Page, error) {
+	// Snippets which never expire have a NULL expiry, so substitute a date
+	// far in the future to keep them in a consistent position.
+	key := "s.created"
+	if opts.Sort == SortExpires {
+		key = "COALESCE(s.expires, '9999-12-31 23:59:59')"
+	}
+
+	// When paging backwards we walk the list in the opposite direction, then
+	// reverse the results at the end.
+	descending := opts.Descending
+	cursor := opts.After
+	if opts.Before != nil {
+		descending = !descending
+		cursor = opts.Before
+	}
+
+	cmp, order := ">", "ASC"
+	if descending {
+		cmp, order = "<", "DESC"
+	}
+
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL
+	AND s.visibility = 'public'`
+	args := []any{}
+
+	if opts.Tag != "" {
+		stmt += ` AND EXISTS (SELECT 1 FROM snippet_tags st JOIN tags t ON t.id = st.tag_id
+		WHERE st.snippet_id = s.id AND t.name = ?)`
+		args = append(args, opts.Tag)
+	}
+
+	if cursor != nil {
+		stmt += ` AND (` + key + ` ` + cmp + ` ? OR (` + key + ` = ? AND s.slug ` + cmp + ` ?))`
+		args = append(args, cursor.Time, cursor.Time, cursor.Slug)
+	}
+
+	// Fetch one more snippet than we need, so we know whether there's
+	// another page after this one.
+	stmt += ` ORDER BY ` + key + ` ` + order + `, s.slug ` + order + ` LIMIT ?`
+	args = append(args, opts.PageSize+1)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(s.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	more := len(snippets) > opts.PageSize
+	if more {
+		snippets = snippets[:opts.PageSize]
+	}
+
+	if opts.Before != nil {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+
+	page := &Page{Snippets: snippets}
+	if len(snippets) == 0 {
+		return page, nil
+	}
+
+	first := cursorFor(snippets[0], opts.Sort)
+	last := cursorFor(snippets[len(snippets)-1], opts.Sort)
+
+	switch {
+	case opts.Before != nil:
+		// We came from the page after this one, so it must exist.
+		page.Next = last
+		if more {
+			page.Prev = first
+		}
+	case opts.After != nil:
+		page.Prev = first
+		if more {
+			page.Next = last
+		}
+	default:
+		if more {
+			page.Next = last
+		}
+	}
+
+	return page, nil
+}
+
+// This will return the snippets matching a search query, best matches first,
+// skipping the first offset results and returning at most limit of them.
+// Public snippets are included for everyone, but unlisted and private
+// snippets are only included for their owner, given by userID. Snippets with
+// a view limit are excluded for everyone else too, since search results show
+// part of their content.
+func (m *SnippetModel) Search(q SearchQuery, userID int, offset, limit int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted IS NULL
+	AND MATCH(s.title, s.content) AGAINST(? IN BOOLEAN MODE)
+	AND ((s.visibility = 'public' AND s.max_views IS NULL) OR (s.user_id IS NOT NULL AND s.user_id = ?))
+	ORDER BY MATCH(s.title, s.content) AGAINST(? IN BOOLEAN MODE) DESC, s.created DESC
+	LIMIT ? OFFSET ?`
+
+	against := q.booleanMode()
+
+	rows, err := m.DB.Query(stmt, against, userID, against, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(s.scanDest()...)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// maxSlugAttempts is the number of times Insert will generate a new slug if
+// the previous one was already taken. With 62^12 possible slugs a collision
+// is vanishingly unlikely, so this is just a safety net.
+const maxSlugAttempts = 5
+
+// This will insert a new snippet owned by the user with the given userID,
+// returning the slug that it was given. Pass a userID of 0 for an anonymous
+// snippet, and the zero time for expires to create a snippet which never
+// expires.
+func (m *SnippetModel) Insert(userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) (string, error) { 
+	for i := 0; i < maxSlugAttempts; i++ {
+		slug, err := newSlug()
+		if err != nil {
+			return "", err
+		}
+
+		id, err := m.insert(slug, userID, title, content, language, format, expires, visibility, maxViews, tags)
+		if err != nil {
+			// If another snippet already has this slug, try again with a
+			// new one.
+			var mySQLError *mysql.MySQLError
+			if errors.As(err, &mySQLError) {
+				if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "snippets_uc_slug") {
+					continue
+				}
+			}
+			return "", err
+		}
+
+		m.index(&Snippet{ID: id, Slug: slug, Title: title, Content: content, Language: language, Format: format, Expires: expires, Visibility: visibility, MaxViews: maxViews, UserID: userID, Tags: tags})
+
+		return slug, nil
+	}
+
+	return "", errors.New("models: unable to generate a unique snippet slug")
+}
+
+func (m *SnippetModel) insert(slug string, userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) (int, error) {
+	// The snippet and its first revision are written in a single transaction,
+	// so that a snippet never exists without any history.
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	// Write the SQL statement we want to execute. I've split it over two lines
 	// for readability (which is why it's surrounded with backquotes instead
 	// of normal double quotes).
-	stmt := `INSERT INTO snippets (title, content, created, expires) 
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (slug, user_id, title, content, language, format, created, expires, visibility, max_views) 
+	VALUES(?, NULLIF(?, 0), ?, ?, ?, ?, UTC_TIMESTAMP(), ?, ?, NULLIF(?, 0))`
 	
-	// Use the Exec() method on the embedded connection pool to execute the 
-	// statement. The first parameter is the SQL statement, followed by the 
-	// title, content and expiry values for the placeholder parameters. This 
-	// method returns a sql.Result type, which contains some basic
-	// information about what happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires) 
+	// Use the Exec() method on the transaction to execute the statement. The
+	// first parameter is the SQL statement, followed by the slug, owner (or 0
+	// for an anonymous paste),
+	// title, content, language, format, expiry, visibility and view limit
+	// values for the placeholder parameters. This method returns a sql.Result type, which
+	// contains some basic information about what happened when the statement
+	// was executed.
+	result, err := tx.Exec(stmt, slug, userID, title, content, language, format, expiresParam(expires), visibility, maxViews) 
 	if err != nil {
 		return 0, err 
 	}
 
 	// Use the LastInsertId() method on the result to get the ID of our 
-	// newly inserted record in the snippets table.
+	// newly inserted record in the snippets table, which we need in order to
+	// record the first revision.
 	id, err := result.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
 
-	// The ID returned has the type int64, so we convert it to an int type 
-	// before returning.
-	return int(id), nil
-}
\ No newline at end of file
+	err = insertRevision(tx, int(id), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setTags(tx, int(id), tags)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+// This will update the title, content, language, format, expiry, visibility,
+// view limit and tags of an existing snippet on behalf of the user with the given userID,
+// recording the result as a new revision. Pass the zero time for expires to
+// make the snippet never expire. Changing the view limit starts counting
+// views again from zero, since otherwise a limit at or below the views so far
+// would leave the snippet unviewable but never deleted.
+func (m *SnippetModel) Update(id int, userID int, title string, content string, language string, format string, expires time.Time, visibility string, maxViews int, tags []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// MySQL applies the assignments from left to right, so views has to be
+	// compared with the old max_views before that's changed.
+	stmt := `UPDATE snippets SET title = ?, content = ?, language = ?, format = ?,
+	expires = ?, visibility = ?,
+	views = IF(max_views <=> NULLIF(?, 0), views, 0),
+	max_views = NULLIF(?, 0)
+	WHERE id = ?`
+
+	_, err = tx.Exec(stmt, title, content, language, format, expiresParam(expires), visibility, maxViews, maxViews, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(tx, id, userID)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(tx, id, tags)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Only the owner can edit a snippet, so userID is also the owner.
+	m.index(&Snippet{ID: id, Title: title, Content: content, Language: language, Format: format, Expires: expires, Visibility: visibility, MaxViews: maxViews, UserID: userID, Tags: tags})
+
+	return nil
+}
+
+// This will count a view of a snippet which has a view limit, and return the
+// snippet as it was seen. If that view used up the last of the limit then the
+// snippet is permanently deleted before returning, and its LastView() method
+// will report true. If the snippet has already used up its views (or has
+// otherwise gone) then ErrNoRecord is returned.
+//
+// The counter is incremented with a conditional UPDATE, which takes a lock on
+// the row for the rest of the transaction. That means concurrent requests are
+// serialized, and exactly MaxViews of them will succeed.
+func (m *SnippetModel) RecordView(id int) (*Snippet, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE snippets SET views = views + 1
+	WHERE id = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted IS NULL
+	AND (max_views IS NULL OR views < max_views)`
+
+	result, err := tx.Exec(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireRowsAffected(result)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt = `SELECT ` + snippetColumns + `
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.id = ?`
+
+	s := &Snippet{}
+
+	err = tx.QueryRow(stmt, id).Scan(s.scanDest()...)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LastView() {
+		_, err = tx.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.LastView() {
+		m.unindex(id)
+	}
+
+	return s, nil
+}
+
+// This will move a snippet to the trash. Trashed snippets are no longer
+// returned by Get or Latest, but can be restored by their owner.
+func (m *SnippetModel) Delete(id int) error {
+	stmt := `UPDATE snippets SET deleted = UTC_TIMESTAMP() WHERE id = ? AND deleted IS NULL`
+
+	_, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	m.unindex(id)
+
+	return nil
+}
+
+// This will return the snippets in a user's trash, most recently deleted
+// first.
+func (m *SnippetModel) Trash(userID int) ([]*Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `, s.deleted
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.deleted IS NOT NULL AND s.user_id = ?
+	ORDER BY s.deleted DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*Snippet{}
+
+	for rows.Next() {
+		s := &Snippet{}
+		var deleted sql.NullTime
+
+		err = rows.Scan(append(s.scanDest(), &deleted)...)
+		if err != nil {
+			return nil, err
+		}
+		s.Deleted = deleted.Time
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// This will take a snippet out of the given user's trash. If the snippet
+// isn't in their trash then ErrNoRecord is returned.
+func (m *SnippetModel) Restore(slug string, userID int) error {
+	stmt := `UPDATE snippets SET deleted = NULL
+	WHERE slug = ? AND user_id = ? AND deleted IS NOT NULL`
+
+	result, err := m.DB.Exec(stmt, slug, userID)
+	if err != nil {
+		return err
+	}
+
+	err = requireRowsAffected(result)
+	if err != nil {
+		return err
+	}
+
+	// The restored snippet needs to be searchable again. It might have
+	// expired while it was in the trash, in which case there's nothing to
+	// index.
+	if m.Indexer != nil {
+		s, err := m.Get(slug)
+		if err != nil {
+			if errors.Is(err, ErrNoRecord) {
+				return nil
+			}
+			return err
+		}
+		m.index(s)
+	}
+
+	return nil
+}
+
+// This will permanently delete a snippet (along with its revisions) from the
+// given user's trash. If the snippet isn't in their trash then ErrNoRecord is
+// returned.
+func (m *SnippetModel) Purge(slug string, userID int) error {
+	stmt := `DELETE FROM snippets WHERE slug = ? AND user_id = ? AND deleted IS NOT NULL`
+
+	result, err := m.DB.Exec(stmt, slug, userID)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+// This will permanently delete all snippets which have been in the trash for
+// longer than the given retention period, returning the number of snippets
+// removed.
+func (m *SnippetModel) PurgeTrashed(retention time.Duration) (int64, error) {
+	stmt := `DELETE FROM snippets WHERE deleted < ?`
+
+	result, err := m.DB.Exec(stmt, time.Now().UTC().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// This will permanently delete up to batchSize snippets which have expired,
+// returning the number deleted. Deleting in limited batches keeps each
+// statement short, so it doesn't hold locks that would block other queries
+// for long.
+func (m *SnippetModel) DeleteExpired(batchSize int) (int64, error) {
+	// We need to know which snippets are deleted so that they can be taken
+	// out of the search index, so select a batch of ids first and then
+	// delete those.
+	rows, err := m.DB.Query(`SELECT id FROM snippets WHERE expires <= UTC_TIMESTAMP() LIMIT ?`, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []any{}
+
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	stmt := `DELETE FROM snippets WHERE id IN (` + placeholders + `) AND expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, ids...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		m.unindex(id.(int))
+	}
+
+	return result.RowsAffected()
+}
+
+// requireRowsAffected returns ErrNoRecord if a statement didn't change any
+// rows.
+func requireRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// The limits on the tags a snippet can have. These are enforced by the
+// snippet forms; the database only limits the length of a tag.
+const (
+	MaxTags = 10
+	MaxTagLength = 32
+)
+
+// ParseTags splits a comma-separated list of tags, as entered on the snippet
+// forms, into a slice. Tags are trimmed and lower-cased, and blank or
+// duplicate tags are dropped.
+func ParseTags(s string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
+
+// tagList scans the comma-separated list of tag names produced by the
+// GROUP_CONCAT() in snippetColumns into a slice, which is empty if the column
+// is NULL. Tag names can't contain commas, so this is unambiguous.
+type tagList struct {
+	tags *[]string
+}
+
+func (t tagList) Scan(value any) error {
+	var ns sql.NullString
+
+	err := ns.Scan(value)
+	if err != nil {
+		return err
+	}
+
+	*t.tags = []string{}
+	if ns.String != "" {
+		*t.tags = strings.Split(ns.String, ",")
+	}
+
+	return nil
+}
+
+// setTags replaces the tags on a snippet, creating any tags which don't exist
+// yet. It is called as part of the transactions which insert and update
+// snippets.
+func setTags(tx *sql.Tx, snippetID int, tags []string) error {
+	_, err := tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = ?`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		// If the tag already exists, LAST_INSERT_ID(id) makes the result's
+		// LastInsertId() return its id, so we get the id either way.
+		result, err := tx.Exec(`INSERT INTO tags (name) VALUES (?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`, tag)
+		if err != nil {
+			return err
+		}
+
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`, snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The scopes an API token can be given. A token can only be used for the
+// API endpoints covered by its scopes.
+const (
+	ScopeSnippetsRead = "snippets:read"
+	ScopeSnippetsWrite = "snippets:write"
+)
+
+// Scopes lists every scope, in the order they're shown on the tokens page.
+var Scopes = []string{ScopeSnippetsRead, ScopeSnippetsWrite}
+
+// apiTokenPrefix is added to the start of every API token, so that they're
+// easy to recognise (and to search for if one is leaked).
+const apiTokenPrefix = "sbx_"
+
+// newToken returns a random token with the given prefix, along with the hash
+// of it which should be stored in the database. 20 random bytes gives 160
+// bits of randomness, so the tokens can't be guessed and a fast hash is good
+// enough to protect them.
+func newToken(prefix string) (string, []byte, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plaintext := prefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+
+	return plaintext, hashToken(plaintext), nil
+}
+
+// hashToken returns the SHA-256 hash of a token, which is how it's stored
+// and looked up in the database.
+func hashToken(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// An APIToken is a personal access token which lets a program use the API on
+// behalf of a user. The token itself isn't stored, so it isn't part of the
+// struct.
+type APIToken struct {
+	ID int
+	UserID int
+	Name string
+	Scopes []string
+	Created time.Time
+	// Expires and LastUsed are the zero time for tokens which never expire
+	// and tokens which have never been used.
+	Expires time.Time
+	LastUsed time.Time
+}
+
+// HasScope reports whether the token has been given a scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Define an APITokenModel type which wraps a database connection pool.
+type APITokenModel struct {
+	DB *sql.DB
+}
+
+// This will create a new token for a user, returning the token itself. This
+// is the only time it's available, since only its hash is stored. Pass the
+// zero time for expires to create a token which never expires.
+func (m *APITokenModel) Insert(userID int, name string, scopes []string, expires time.Time) (string, error) {
+	plaintext, hash, err := newToken(apiTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO api_tokens (user_id, name, token_hash, scopes, created, expires)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP(), ?)`
+
+	_, err = m.DB.Exec(stmt, userID, name, hash, strings.Join(scopes, " "), expiresParam(expires))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// This will return all of a user's tokens, including expired ones, newest
+// first.
+func (m *APITokenModel) All(userID int) ([]*APIToken, error) {
+	stmt := `SELECT id, user_id, name, scopes, created, expires, last_used
+	FROM api_tokens WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*APIToken{}
+
+	for rows.Next() {
+		t := &APIToken{}
+		var scopes string
+
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, nullTime{&t.Expires}, nullTime{&t.LastUsed})
+		if err != nil {
+			return nil, err
+		}
+
+		t.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// This will look up the token given by an API client, returning
+// ErrInvalidCredentials if it doesn't exist or has expired. The time the
+// token was last used is updated as well.
+func (m *APITokenModel) Authenticate(plaintext string) (*APIToken, error) {
+	stmt := `SELECT id, user_id, name, scopes, created, expires, last_used
+	FROM api_tokens
+	WHERE token_hash = ? AND (expires IS NULL OR expires > UTC_TIMESTAMP())`
+
+	t := &APIToken{}
+	var scopes string
+
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, nullTime{&t.Expires}, nullTime{&t.LastUsed})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+
+	_, err = m.DB.Exec("UPDATE api_tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?", t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// This will revoke one of a user's tokens by deleting it. If the user
+// doesn't have a token with that ID then ErrNoRecord is returned.
+func (m *APITokenModel) Revoke(id, userID int) error {
+	result, err := m.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
@@ -0,0 +1,196 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// recoveryCodeCount is the number of recovery codes a user is given when they
+// turn on two-factor authentication.
+const recoveryCodeCount = 10
+
+// recoveryCodeAlphabet leaves out characters which are easily confused with
+// each other, such as 0 and o or 1 and l.
+const recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+// newRecoveryCode returns a random recovery code such as "7kq2m-9xv4p". Ten
+// characters from a 31 character alphabet gives nearly 50 bits of
+// randomness, which is plenty for a code that can only be tried a few times
+// per login.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		// The small bias from using % here doesn't matter for codes of this
+		// length.
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// normalizeRecoveryCode puts a recovery code typed in by a user into the form
+// it was hashed in, so that case and the hyphen don't matter.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// Define a TwoFactorModel type which wraps a database connection pool. It
+// manages each user's TOTP secret and recovery codes.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// This will return a user's TOTP secret, or the empty string if they haven't
+// turned on two-factor authentication, along with the counter value of the
+// last code they used.
+func (m *TwoFactorModel) Secret(userID int) (string, int64, error) {
+	var secret sql.NullString
+	var lastCounter int64
+
+	stmt := "SELECT totp_secret, totp_last_counter FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, userID).Scan(&secret, &lastCounter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, ErrNoRecord
+		}
+		return "", 0, err
+	}
+
+	return secret.String, lastCounter, nil
+}
+
+// This will turn on two-factor authentication for a user with the given
+// secret, replacing any recovery codes they had with a new set. The new codes
+// are returned, and this is the only time they're available.
+func (m *TwoFactorModel) Enable(userID int, secret string, counter int64) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The code used to confirm the secret counts as used.
+	_, err = tx.Exec("UPDATE users SET totp_secret = ?, totp_last_counter = ? WHERE id = ?", secret, counter, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// This will turn off two-factor authentication for a user, deleting their
+// secret and recovery codes.
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_last_counter = 0 WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// This will record that a user has used the TOTP code for the given counter
+// value. It returns ErrNoRecord if a code for that counter (or a later one)
+// has already been used, so that codes can't be replayed. The check and the
+// update are a single statement, so two requests racing with the same code
+// can't both succeed.
+func (m *TwoFactorModel) UseCounter(userID int, counter int64) error {
+	stmt := `UPDATE users SET totp_last_counter = ?
+	WHERE id = ? AND totp_last_counter < ?`
+
+	result, err := m.DB.Exec(stmt, counter, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+// This will use up one of a user's recovery codes. If they don't have a
+// matching code then ErrNoRecord is returned.
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) error {
+	stmt := "DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?"
+
+	result, err := m.DB.Exec(stmt, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+// This will return the number of unused recovery codes a user has.
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+
+	err := m.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?", userID).Scan(&n)
+	return n, err
+}
+
+// This will give a user a new set of recovery codes, replacing any they
+// already had.
+func (m *TwoFactorModel) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and stores the hashes
+// of a new set, returning the new codes.
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES(?, ?)", userID, hashToken(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
@@ -18,6 +18,12 @@ type User struct {
 	Email string 
 	HashedPassword []byte 
 	Created time.Time
+	// EmailVerified is true once the user has followed the link in the
+	// verification email sent when they signed up.
+	EmailVerified bool
+	// TwoFactorEnabled is true if the user has to give a TOTP code or a
+	// recovery code when they log in.
+	TwoFactorEnabled bool
 }
 
     
@@ -26,12 +32,13 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// We'll use the Insert method to add a new record to the "users" table.
-func (m *UserModel) Insert(name, email, password string) error { 
+// We'll use the Insert method to add a new record to the "users" table,
+// returning the new user's ID.
+func (m *UserModel) Insert(name, email, password string) (int, error) { 
 	// Create a bcrypt hash of the plain-text password.
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12) 
 	if err != nil {
-		return err 
+		return 0, err 
 	}
 	
 	stmt := `INSERT INTO users (name, email, hashed_password, created) 
@@ -39,7 +46,7 @@ func (m *UserModel) Insert(name, email, password string) error {
 	
 	// Use the Exec() method to insert the user details and hashed password 
 	// into the users table.
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
 		// If this returns an error, we use the errors.As() function to check 
 		// whether the error has the type *mysql.MySQLError. If it does, the
@@ -50,13 +57,18 @@ func (m *UserModel) Insert(name, email, password string) error {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") { 
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			} 
 		}
-		return err 
+		return 0, err 
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return int(id), nil
 }
 
 // We'll use the Authenticate method to verify whether a user exists with // the provided email address and password. This will return the relevant // user ID if they do.
@@ -98,4 +110,111 @@ func (m *UserModel) Exists(id int) (bool, error) {
 
 	err := m.DB.QueryRow(stmt, id).Scan(&exists)
 	return exists, err
-}
\ No newline at end of file
+}
+// We'll use the Get method to fetch the details of a specific user, for
+// showing on their account. The hashed password is left out.
+func (m *UserModel) Get(id int) (*User, error) {
+	u := &User{}
+
+	stmt := "SELECT id, name, email, created, email_verified, totp_secret IS NOT NULL FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.EmailVerified, &u.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// We'll use the GetByEmail method to find the user with an email address,
+// for sending them a password reset link. If there isn't one then
+// ErrNoRecord is returned.
+func (m *UserModel) GetByEmail(email string) (*User, error) {
+	u := &User{}
+
+	stmt := "SELECT id, name, email, created, email_verified, totp_secret IS NOT NULL FROM users WHERE email = ?"
+
+	err := m.DB.QueryRow(stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.EmailVerified, &u.TwoFactorEnabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// We'll use the UpdatePassword method to set a new password for a user. This
+// also increments their session version, which logs out all of their
+// existing sessions.
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ?, session_version = session_version + 1
+	WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	return requireRowsAffected(result)
+}
+
+// We'll use the SessionVersion method to fetch a user's current session
+// version. Sessions logged in with an older version are no longer valid. If
+// the user doesn't exist then ErrNoRecord is returned.
+func (m *UserModel) SessionVersion(id int) (int, error) {
+	var version int
+
+	stmt := "SELECT session_version FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(stmt, id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// We'll use the SetEmailVerified method to record that a user has verified
+// their email address.
+func (m *UserModel) SetEmailVerified(id int) error {
+	_, err := m.DB.Exec("UPDATE users SET email_verified = TRUE WHERE id = ?", id)
+	return err
+}
+
+// We'll use the UpdateName method to change a user's display name.
+func (m *UserModel) UpdateName(id int, name string) error {
+	_, err := m.DB.Exec("UPDATE users SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+// We'll use the UpdateEmail method to change a user's email address. The new
+// address hasn't been verified yet, so email_verified is reset. If another
+// user already has the address then ErrDuplicateEmail is returned.
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	stmt := "UPDATE users SET email = ?, email_verified = FALSE WHERE id = ?"
+
+	_, err := m.DB.Exec(stmt, email, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return ErrDuplicateEmail
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,256 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// BM25 tuning parameters. k1 controls how quickly repeated occurrences of a
+// term stop adding to the score, and b controls how strongly long documents
+// are penalized. These are the values most commonly used in practice.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// titleWeight is the number of times each title term is counted, so that a
+// match in the title ranks above the same match buried in the content.
+const titleWeight = 3
+
+// Document is a single item to be indexed. Only public documents, or those
+// owned by the user searching, are returned by Search.
+type Document struct {
+	ID      int
+	Title   string
+	Content string
+	OwnerID int
+	Public  bool
+}
+
+// Result is a single search hit.
+type Result struct {
+	ID    int
+	Score float64
+}
+
+// Index is an in-memory inverted index which ranks documents using BM25. It
+// is safe for concurrent use. Changes are held in memory until Flush is
+// called, at which point they're written to the underlying Store.
+type Index struct {
+	mu    sync.RWMutex
+	store Store
+	data  *Snapshot
+	dirty bool
+}
+
+// New returns an Index loaded from the given store.
+func New(store Store) (*Index, error) {
+	data, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = newSnapshot()
+	}
+
+	return &Index{store: store, data: data}, nil
+}
+
+// Add indexes the document, replacing any existing document with the same ID.
+func (ix *Index) Add(doc Document) {
+	freqs := make(map[string]int)
+	length := 0
+
+	for _, term := range Tokenize(doc.Title) {
+		freqs[term] += titleWeight
+		length += titleWeight
+	}
+	for _, term := range Tokenize(doc.Content) {
+		freqs[term]++
+		length++
+	}
+
+	info := DocInfo{
+		Length:  length,
+		OwnerID: doc.OwnerID,
+		Public:  doc.Public,
+		Terms:   make([]string, 0, len(freqs)),
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(doc.ID)
+
+	for term, freq := range freqs {
+		postings, ok := ix.data.Postings[term]
+		if !ok {
+			postings = make(map[int]int)
+			ix.data.Postings[term] = postings
+		}
+		postings[doc.ID] = freq
+		info.Terms = append(info.Terms, term)
+	}
+
+	ix.data.Docs[doc.ID] = info
+	ix.data.TotalLength += length
+	ix.dirty = true
+}
+
+// Remove deletes the document with the given ID from the index. It's not an
+// error if there is no such document.
+func (ix *Index) Remove(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(id)
+}
+
+func (ix *Index) remove(id int) {
+	info, ok := ix.data.Docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range info.Terms {
+		postings := ix.data.Postings[term]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(ix.data.Postings, term)
+		}
+	}
+
+	delete(ix.data.Docs, id)
+	ix.data.TotalLength -= info.Length
+	ix.dirty = true
+}
+
+// Reset removes every document from the index.
+func (ix *Index) Reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.data = newSnapshot()
+	ix.dirty = true
+}
+
+// Len returns the number of documents in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.data.Docs)
+}
+
+// Search returns the documents matching every term in the query, ordered by
+// descending BM25 score. Documents which aren't public are only included if
+// they are owned by viewerID. The offset and limit parameters select a page
+// of the results.
+func (ix *Index) Search(query string, viewerID, offset, limit int) []Result {
+	terms := uniqueTerms(Tokenize(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	// Start with the rarest term, so that the candidate set is as small as
+	// possible, and then discard any candidates which are missing one of the
+	// other terms.
+	sort.Slice(terms, func(i, j int) bool {
+		return len(ix.data.Postings[terms[i]]) < len(ix.data.Postings[terms[j]])
+	})
+
+	var results []Result
+
+	n := float64(len(ix.data.Docs))
+	avgLength := float64(ix.data.TotalLength) / n
+
+candidates:
+	for id := range ix.data.Postings[terms[0]] {
+		info := ix.data.Docs[id]
+		if !info.Public && (viewerID == 0 || info.OwnerID != viewerID) {
+			continue
+		}
+
+		score := 0.0
+		for _, term := range terms {
+			postings := ix.data.Postings[term]
+			freq, ok := postings[id]
+			if !ok {
+				continue candidates
+			}
+
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			tf := float64(freq)
+			norm := 1 - bm25B + bm25B*float64(info.Length)/avgLength
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+
+		results = append(results, Result{ID: id, Score: score})
+	}
+
+	// Break ties on the ID, newest first, so that paging through results is
+	// stable.
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+
+	if offset >= len(results) {
+		return nil
+	}
+	results = results[offset:]
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// Flush writes the index to its store if it has changed since it was loaded
+// or last flushed.
+func (ix *Index) Flush() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if !ix.dirty {
+		return nil
+	}
+
+	err := ix.store.Save(ix.data)
+	if err != nil {
+		return err
+	}
+
+	ix.dirty = false
+	return nil
+}
+
+// SaveTo writes the whole index to the given store, regardless of whether it
+// has changed. It doesn't affect the index's own store.
+func (ix *Index) SaveTo(store Store) error {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return store.Save(ix.data)
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0]
+
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,203 @@
+package search
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTestIndex returns an empty index using a MemoryStore, with the given
+// documents added.
+func newTestIndex(t *testing.T, docs ...Document) *Index {
+	t.Helper()
+
+	ix, err := New(MemoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, doc := range docs {
+		ix.Add(doc)
+	}
+
+	return ix
+}
+
+// resultIDs returns the IDs of the results, in order.
+func resultIDs(results []Result) []int {
+	var ids []int
+	for _, result := range results {
+		ids = append(ids, result.ID)
+	}
+	return ids
+}
+
+func TestIndexSearchRanking(t *testing.T) {
+	ix := newTestIndex(t,
+		Document{ID: 1, Title: "Notes", Content: "A goroutine example with some other words in it", Public: true},
+		Document{ID: 2, Title: "Goroutines", Content: "Starting a goroutine", Public: true},
+		Document{ID: 3, Title: "Channels", Content: "goroutine goroutine goroutine", Public: true},
+		Document{ID: 4, Title: "Unrelated", Content: "Nothing to see here", Public: true},
+	)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{
+			name:  "Title matches rank first",
+			query: "goroutine",
+			want:  []int{2, 3, 1},
+		},
+		{
+			name:  "Every term must match",
+			query: "goroutine starting",
+			want:  []int{2},
+		},
+		{
+			name:  "Stemmed query",
+			query: "goroutines",
+			want:  []int{2, 3, 1},
+		},
+		{
+			name:  "No matches",
+			query: "missing",
+			want:  nil,
+		},
+		{
+			name:  "Only stop words",
+			query: "the",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultIDs(ix.Search(tt.query, 0, 0, 0))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSearchTiesAndPaging(t *testing.T) {
+	ix := newTestIndex(t,
+		Document{ID: 1, Content: "gopher", Public: true},
+		Document{ID: 2, Content: "gopher", Public: true},
+		Document{ID: 3, Content: "gopher", Public: true},
+	)
+
+	// Equal scores are ordered by ID, newest first.
+	if got, want := resultIDs(ix.Search("gopher", 0, 0, 0)), []int{3, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got, want := resultIDs(ix.Search("gopher", 0, 1, 1)), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if got := ix.Search("gopher", 0, 3, 1); got != nil {
+		t.Errorf("got %v; want nil", got)
+	}
+}
+
+func TestIndexSearchVisibility(t *testing.T) {
+	ix := newTestIndex(t,
+		Document{ID: 1, Content: "gopher", OwnerID: 7, Public: true},
+		Document{ID: 2, Content: "gopher", OwnerID: 7, Public: false},
+		Document{ID: 3, Content: "gopher", OwnerID: 8, Public: false},
+	)
+
+	tests := []struct {
+		name     string
+		viewerID int
+		want     []int
+	}{
+		{"Anonymous", 0, []int{1}},
+		{"Owner", 7, []int{2, 1}},
+		{"Other user", 9, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultIDs(ix.Search("gopher", tt.viewerID, 0, 0))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexUpdateAndRemove(t *testing.T) {
+	ix := newTestIndex(t,
+		Document{ID: 1, Title: "Old title", Content: "gopher", Public: true},
+		Document{ID: 2, Content: "gopher", Public: true},
+	)
+
+	// Adding a document with the same ID replaces it, so its old terms
+	// no longer match.
+	ix.Add(Document{ID: 1, Title: "New title", Content: "badger", Public: true})
+
+	if got, want := resultIDs(ix.Search("gopher", 0, 0, 0)), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gopher: got %v; want %v", got, want)
+	}
+	if got, want := resultIDs(ix.Search("badger", 0, 0, 0)), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("badger: got %v; want %v", got, want)
+	}
+	if got := ix.Search("old", 0, 0, 0); got != nil {
+		t.Errorf("old: got %v; want nil", got)
+	}
+
+	ix.Remove(1)
+	ix.Remove(99)
+
+	if got := ix.Search("badger", 0, 0, 0); got != nil {
+		t.Errorf("badger after remove: got %v; want nil", got)
+	}
+	if got := ix.Len(); got != 1 {
+		t.Errorf("Len() = %d; want 1", got)
+	}
+	if _, ok := ix.data.Postings["badger"]; ok {
+		t.Error("postings for a removed document's terms were kept")
+	}
+
+	ix.Reset()
+
+	if got := ix.Len(); got != 0 {
+		t.Errorf("Len() after reset = %d; want 0", got)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "search.idx")}
+
+	ix, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 0 {
+		t.Fatalf("new index from a missing file has %d documents", ix.Len())
+	}
+
+	ix.Add(Document{ID: 1, Title: "Goroutines", Content: "Starting a goroutine", Public: true})
+	ix.Add(Document{ID: 2, Content: "goroutine", OwnerID: 7, Public: false})
+	ix.Add(Document{ID: 3, Content: "goroutine", Public: true})
+	ix.Remove(3)
+
+	err = ix.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reloaded.data, ix.data) {
+		t.Errorf("reloaded snapshot differs from the original")
+	}
+	if got, want := resultIDs(reloaded.Search("goroutine", 7, 0, 0)), resultIDs(ix.Search("goroutine", 7, 0, 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded search = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+package search
+
+import (
+	"strings"
+
+	"snippetbox.jamespaul.com/internal/models"
+)
+
+// SnippetIndexer adapts an Index to the models.SnippetIndexer interface, so
+// that it can be plugged into a SnippetModel and kept up to date as snippets
+// change.
+type SnippetIndexer struct {
+	Index *Index
+}
+
+func (si SnippetIndexer) IndexSnippet(s *models.Snippet) {
+	si.Index.Add(SnippetDocument(s))
+}
+
+func (si SnippetIndexer) RemoveSnippet(id int) {
+	si.Index.Remove(id)
+}
+
+// SnippetDocument converts a snippet into a Document. The snippet's tags are
+// indexed along with its content. Only public snippets
+// without a view limit are treated as public: unlisted and private snippets
+// shouldn't be discoverable by other users, and search results would give
+// away the content of view-limited ones without counting a view.
+func SnippetDocument(s *models.Snippet) Document {
+	return Document{
+		ID:      s.ID,
+		Title:   s.Title,
+		Content: s.Content + "\n" + strings.Join(s.Tags, " "),
+		OwnerID: s.UserID,
+		Public:  s.Visibility == models.VisibilityPublic && s.MaxViews == 0,
+	}
+}
+
+// RebuildSnippets empties the index and then adds every snippet which hasn't
+// expired or been deleted, returning the number of snippets indexed. It
+// doesn't flush the index.
+func RebuildSnippets(ix *Index, snippets *models.SnippetModel) (int, error) {
+	ix.Reset()
+
+	n := 0
+	err := snippets.Each(func(s *models.Snippet) error {
+		ix.Add(SnippetDocument(s))
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
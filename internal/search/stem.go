@@ -0,0 +1,311 @@
+package search
+
+// This file implements the Porter stemming algorithm, as described in
+// M.F. Porter, "An algorithm for suffix stripping", Program 14(3), 1980. It
+// reduces English words to a common stem so that, for example, "connected",
+// "connecting" and "connection" all match each other.
+
+// Stem returns the Porter stem of a lower-case word. Words of two letters or
+// fewer, and words containing anything other than the letters a to z, are
+// returned unchanged.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &stemmer{b: []byte(word)}
+	s.step1ab()
+	if len(s.b) > 1 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return string(s.b)
+}
+
+// stemmer holds the word being stemmed. j is the index of the last letter of
+// the stem, as set by ends(); the letters after it are the suffix being
+// considered.
+type stemmer struct {
+	b []byte
+	j int
+}
+
+// cons reports whether b[i] is a consonant. 'y' counts as a consonant at the
+// start of a word or after a vowel.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of consonant-vowel sequences in b[0..j]. Writing c
+// for a consonant sequence and v for a vowel sequence, every word has the
+// form [c](vc){m}[v].
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for {
+		if i > s.j {
+			return n
+		}
+		if !s.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > s.j {
+				return n
+			}
+			if s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > s.j {
+				return n
+			}
+			if !s.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// vowelInStem reports whether b[0..j] contains a vowel.
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleC reports whether b[i-1..i] is a double consonant.
+func (s *stemmer) doubleC(i int) bool {
+	if i < 1 || s.b[i] != s.b[i-1] {
+		return false
+	}
+	return s.cons(i)
+}
+
+// cvc reports whether b[i-2..i] is consonant-vowel-consonant and the second
+// consonant isn't w, x or y. This is used to restore an 'e' at the end of
+// short words, e.g. hop(e)ing.
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether the word ends with suffix, setting j to the end of
+// the stem before it if so.
+func (s *stemmer) ends(suffix string) bool {
+	k := len(s.b)
+	if len(suffix) > k || string(s.b[k-len(suffix):]) != suffix {
+		return false
+	}
+	s.j = k - len(suffix) - 1
+	return true
+}
+
+// setTo replaces everything after j with suffix.
+func (s *stemmer) setTo(suffix string) {
+	s.b = append(s.b[:s.j+1], suffix...)
+}
+
+// r replaces the suffix found by ends() with suffix if the stem is long
+// enough.
+func (s *stemmer) r(suffix string) {
+	if s.m() > 0 {
+		s.setTo(suffix)
+	}
+}
+
+// trim removes the last n letters of the word.
+func (s *stemmer) trim(n int) {
+	s.b = s.b[:len(s.b)-n]
+}
+
+func (s *stemmer) last() byte {
+	return s.b[len(s.b)-1]
+}
+
+// step1ab gets rid of plurals and -ed or -ing suffixes.
+func (s *stemmer) step1ab() {
+	if s.last() == 's' {
+		switch {
+		case s.ends("sses"):
+			s.trim(2)
+		case s.ends("ies"):
+			s.setTo("i")
+		case s.b[len(s.b)-2] != 's':
+			s.trim(1)
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.trim(1)
+		}
+		return
+	}
+
+	if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.b = s.b[:s.j+1]
+		s.j = len(s.b) - 1
+
+		switch {
+		case s.ends("at"):
+			s.setTo("ate")
+		case s.ends("bl"):
+			s.setTo("ble")
+		case s.ends("iz"):
+			s.setTo("ize")
+		case s.doubleC(len(s.b) - 1):
+			switch s.last() {
+			case 'l', 's', 'z':
+			default:
+				s.trim(1)
+			}
+		default:
+			s.j = len(s.b) - 1
+			if s.m() == 1 && s.cvc(len(s.b)-1) {
+				s.b = append(s.b, 'e')
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[len(s.b)-1] = 'i'
+	}
+}
+
+// step2 maps double suffixes to single ones, so -ization (= -ize plus -ation)
+// maps to -ize, and so on.
+func (s *stemmer) step2() {
+	if len(s.b) < 2 {
+		return
+	}
+
+	replacements := step2Suffixes[s.b[len(s.b)-2]]
+	for _, rep := range replacements {
+		if s.ends(rep[0]) {
+			s.r(rep[1])
+			return
+		}
+	}
+}
+
+var step2Suffixes = map[byte][][2]string{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// step3 deals with -ic-, -full, -ness and similar suffixes.
+func (s *stemmer) step3() {
+	replacements := step3Suffixes[s.last()]
+	for _, rep := range replacements {
+		if s.ends(rep[0]) {
+			s.r(rep[1])
+			return
+		}
+	}
+}
+
+var step3Suffixes = map[byte][][2]string{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// step4 takes off -ant, -ence and similar suffixes, in the context <c>vcvc<v>.
+func (s *stemmer) step4() {
+	if len(s.b) < 2 {
+		return
+	}
+
+	found := false
+	for _, suffix := range step4Suffixes[s.b[len(s.b)-2]] {
+		if s.ends(suffix) {
+			found = true
+			break
+		}
+	}
+
+	// -ion is only removed after an s or a t.
+	if !found && s.ends("ion") && s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't') {
+		found = true
+	}
+
+	if found && s.m() > 1 {
+		s.b = s.b[:s.j+1]
+	}
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step5 removes a final -e if the stem is long enough, and changes -ll to -l
+// if the stem is long enough.
+func (s *stemmer) step5() {
+	s.j = len(s.b) - 1
+
+	if s.last() == 'e' {
+		a := s.m()
+		if a > 1 || (a == 1 && !s.cvc(len(s.b)-2)) {
+			s.trim(1)
+		}
+	}
+
+	if s.last() == 'l' && s.doubleC(len(s.b)-1) && s.m() > 1 {
+		s.trim(1)
+	}
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Snapshot holds the complete contents of an index. Its fields are exported
+// so that it can be encoded with encoding/gob.
+type Snapshot struct {
+	// Postings maps each term to the IDs of the documents containing it, and
+	// the number of times it appears in each of them.
+	Postings map[string]map[int]int
+	// Docs holds the per-document information needed for ranking, filtering
+	// and removal.
+	Docs map[int]DocInfo
+	// TotalLength is the sum of every document's length, used to work out
+	// the average document length.
+	TotalLength int
+}
+
+// DocInfo holds what the index knows about a single document.
+type DocInfo struct {
+	Length  int
+	OwnerID int
+	Public  bool
+	Terms   []string
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		Postings: make(map[string]map[int]int),
+		Docs:     make(map[int]DocInfo),
+	}
+}
+
+// Store persists index snapshots. Load should return a nil snapshot, rather
+// than an error, if nothing has been saved yet.
+type Store interface {
+	Load() (*Snapshot, error)
+	Save(*Snapshot) error
+}
+
+// MemoryStore is a Store which keeps nothing, so every index using it starts
+// out empty. It's useful for tests and for running without a writable disk.
+type MemoryStore struct{}
+
+func (MemoryStore) Load() (*Snapshot, error) {
+	return nil, nil
+}
+
+func (MemoryStore) Save(*Snapshot) error {
+	return nil
+}
+
+// FileStore is a Store which saves snapshots to a single file on disk using
+// encoding/gob.
+type FileStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (s *FileStore) Load() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	data := newSnapshot()
+	err = gob.NewDecoder(f).Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Save writes the snapshot to a temporary file in the same directory and then
+// renames it into place, so that a crash part way through never leaves a
+// truncated index behind.
+func (s *FileStore) Save(data *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = gob.NewEncoder(f).Encode(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.Path)
+}
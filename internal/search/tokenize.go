@@ -0,0 +1,93 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords are common English words which appear in so many documents that
+// they are useless for ranking, so we leave them out of the index entirely.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "with": true,
+}
+
+// Tokenize splits text into the stemmed, lower-case terms which are stored in
+// the index. Because snippets are often code, identifiers are indexed both as
+// a whole and as their camelCase and snake_case parts, so "parseHTTPRequest"
+// produces "parsehttprequest", "pars", "http" and "request". The same terms
+// may appear more than once in the result, once for each occurrence.
+func Tokenize(text string) []string {
+	var terms []string
+
+	for _, word := range splitWords(text) {
+		parts := splitIdentifier(word)
+
+		whole := strings.ToLower(strings.ReplaceAll(word, "_", ""))
+		if len(parts) > 1 {
+			terms = appendTerm(terms, whole)
+		}
+
+		for _, part := range parts {
+			terms = appendTerm(terms, Stem(strings.ToLower(part)))
+		}
+	}
+
+	return terms
+}
+
+func appendTerm(terms []string, term string) []string {
+	if term == "" || stopWords[term] {
+		return terms
+	}
+	return append(terms, term)
+}
+
+// splitWords breaks text into runs of letters, digits and underscores.
+func splitWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// splitIdentifier splits a word on underscores and on changes of case, so
+// that "snake_case" becomes "snake", "case" and "parseHTTPRequest" becomes
+// "parse", "HTTP", "Request". Runs of digits are kept as parts of their own.
+func splitIdentifier(word string) []string {
+	var parts []string
+
+	for _, chunk := range strings.Split(word, "_") {
+		runes := []rune(chunk)
+		start := 0
+
+		for i := 1; i < len(runes); i++ {
+			prev, cur := runes[i-1], runes[i]
+
+			boundary := false
+			switch {
+			case unicode.IsLower(prev) && unicode.IsUpper(cur):
+				// fooBar -> foo|Bar
+				boundary = true
+			case unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+				// HTTPRequest -> HTTP|Request
+				boundary = true
+			case unicode.IsDigit(prev) != unicode.IsDigit(cur):
+				// utf8Decode -> utf|8|Decode
+				boundary = true
+			}
+
+			if boundary {
+				parts = append(parts, string(runes[start:i]))
+				start = i
+			}
+		}
+
+		if start < len(runes) {
+			parts = append(parts, string(runes[start:]))
+		}
+	}
+
+	return parts
+}
@@ -0,0 +1,74 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"caresses", "caress"},
+		{"ponies", "poni"},
+		{"cats", "cat"},
+		{"running", "run"},
+		{"hopeful", "hope"},
+		{"relational", "relat"},
+		{"generalization", "gener"},
+		{"go", "go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			got := Stem(tt.word)
+			if got != tt.want {
+				t.Errorf("Stem(%q) = %q; want %q", tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "Words",
+			text: "Running the tests, quickly!",
+			want: []string{"run", "test", "quickli"},
+		},
+		{
+			name: "Camel case",
+			text: "parseHTTPRequest",
+			want: []string{"parsehttprequest", "pars", "http", "request"},
+		},
+		{
+			name: "Snake case",
+			text: "max_views",
+			want: []string{"maxviews", "max", "view"},
+		},
+		{
+			name: "Stop words only",
+			text: "the and of",
+			want: nil,
+		},
+		{
+			name: "Non-ASCII",
+			text: "Café über",
+			want: []string{"café", "über"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Tokenize(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q; want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
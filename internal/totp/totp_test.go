@@ -0,0 +1,98 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed used by the test vectors in appendix B of
+// RFC 6238, the ASCII string "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC gives eight digit codes. Six digit codes are the last six
+	// digits of those.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != Digits {
+				t.Errorf("got %d digits; want %d", len(got), Digits)
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	got, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Counter(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "287082" {
+		t.Errorf("got %q; want %q", got, "287082")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+
+	code := func(c int64) string {
+		code, err := Code(rfcSecret, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		last   int64
+		want   int64
+		wantOK bool
+	}{
+		{"Current", code(counter), 0, counter, true},
+		{"With spaces", "050 471", 0, counter, true},
+		{"One step behind", code(counter - 1), 0, counter - 1, true},
+		{"One step ahead", code(counter + 1), 0, counter + 1, true},
+		{"Two steps behind", code(counter - 2), 0, 0, false},
+		{"Two steps ahead", code(counter + 2), 0, 0, false},
+		{"Too short", "05047", 0, 0, false},
+		{"Too long", "0504710", 0, 0, false},
+		{"Eight digits", "14050471", 0, 0, false},
+		{"Wrong", "123456", 0, 0, false},
+		{"Already used", code(counter), counter, 0, false},
+		{"Earlier than one already used", code(counter - 1), counter, 0, false},
+		{"Later than one already used", code(counter + 1), counter, counter + 1, true},
+		{"After the last used", code(counter), counter - 1, counter, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Validate(rfcSecret, tt.code, now, tt.last)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got (%d, %t); want (%d, %t)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -12,6 +12,11 @@ import (
 // this pattern once at startup and storing the compiled *regexp.Regexp in a
 // variable is more performant than re-parsing the pattern each time we need it.
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// TagRX matches a single tag: lower-case letters, digits and the characters
+// "+", "-" and ".", starting with a letter or digit. That's enough for names
+// like "c++", "go1.19" or "nginx-proxy", and safe to use in a URL path.
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]*$`)
 	
 // Define a new Validator type which contains a map of validation errors for our
 // form fields.
@@ -77,6 +82,17 @@ func PermittedInt(value int, permittedValues ...int) bool {
 }
 
 
+// PermittedValue() returns true if a value is in a list of permitted values.
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	for i := range permittedValues {
+		if value == permittedValues[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
 // MinChars() returns true if a value contains at least n characters.
 func MinChars(value string, n int) bool { 
 	return utf8.RuneCountInString(value) >= n
@@ -86,4 +102,33 @@ func MinChars(value string, n int) bool {
 // expression pattern.
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value) 
-}
\ No newline at end of file
+}
+
+// MaxItems() returns true if a slice contains no more than n items.
+func MaxItems[T any](items []T, n int) bool {
+	return len(items) <= n
+}
+
+// AllMatch() returns true if every value in a slice matches a provided
+// compiled regular expression pattern.
+func AllMatch(values []string, rx *regexp.Regexp) bool {
+	for _, value := range values {
+		if !rx.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AllMaxChars() returns true if every value in a slice contains no more than
+// n characters.
+func AllMaxChars(values []string, n int) bool {
+	for _, value := range values {
+		if !MaxChars(value, n) {
+			return false
+		}
+	}
+
+	return true
+}
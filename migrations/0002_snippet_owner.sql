@@ -0,0 +1,6 @@
+-- Record the user who created each snippet. Snippets created before this
+-- migration have no owner, so the column is nullable.
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT fk_snippets_user
+    FOREIGN KEY (user_id) REFERENCES users(id);
@@ -0,0 +1,20 @@
+-- Keep a copy of every version of a snippet. Version 1 is the snippet as it
+-- was first created, and each edit adds a new version.
+CREATE TABLE snippet_revisions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    user_id INTEGER NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT snippet_revisions_uc_version UNIQUE (snippet_id, version),
+    CONSTRAINT fk_snippet_revisions_snippet
+        FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT fk_snippet_revisions_user
+        FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Existing snippets start their history at version 1.
+INSERT INTO snippet_revisions (snippet_id, version, user_id, title, content, created)
+SELECT id, 1, user_id, title, content, created FROM snippets;
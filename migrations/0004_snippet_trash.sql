@@ -0,0 +1,5 @@
+-- Deleting a snippet moves it to its owner's trash by setting the deleted
+-- timestamp. Trashed snippets are purged permanently after a retention period.
+ALTER TABLE snippets ADD COLUMN deleted DATETIME NULL;
+
+CREATE INDEX idx_snippets_deleted ON snippets(deleted);
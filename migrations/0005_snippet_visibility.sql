@@ -0,0 +1,6 @@
+-- Public snippets are listed on the home page, unlisted snippets can only be
+-- reached by their URL, and private snippets can only be seen by their owner.
+ALTER TABLE snippets ADD COLUMN visibility ENUM('public', 'unlisted', 'private')
+    NOT NULL DEFAULT 'public';
+
+CREATE INDEX idx_snippets_visibility_created ON snippets(visibility, created);
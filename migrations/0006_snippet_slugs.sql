@@ -0,0 +1,14 @@
+-- Address snippets by a random, URL-safe slug instead of their sequential ID.
+-- The binary collation matters: slugs are case-sensitive base62 strings.
+ALTER TABLE snippets ADD COLUMN slug CHAR(12) CHARACTER SET ascii COLLATE ascii_bin NULL;
+
+-- Give existing snippets a random slug. The application generates its own
+-- slugs for new snippets.
+UPDATE snippets SET slug = LEFT(
+    REPLACE(REPLACE(REPLACE(TO_BASE64(RANDOM_BYTES(16)), '+', ''), '/', ''), '=', ''),
+    12)
+WHERE slug IS NULL;
+
+ALTER TABLE snippets MODIFY slug CHAR(12) CHARACTER SET ascii COLLATE ascii_bin NOT NULL;
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
@@ -0,0 +1,5 @@
+-- Count how many times each snippet has been viewed. A snippet with max_views
+-- set is deleted once it has been viewed that many times; NULL means there is
+-- no limit.
+ALTER TABLE snippets ADD COLUMN views INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE snippets ADD COLUMN max_views INTEGER NULL;
@@ -0,0 +1,3 @@
+-- Lets the background sweeper find expired snippets without scanning the
+-- whole table.
+CREATE INDEX idx_snippets_expires ON snippets(expires);
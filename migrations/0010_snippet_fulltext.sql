@@ -0,0 +1,2 @@
+-- Full-text index used by the /search page.
+ALTER TABLE snippets ADD FULLTEXT INDEX ft_snippets_title_content (title, content);
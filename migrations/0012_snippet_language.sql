@@ -0,0 +1,3 @@
+-- The language used to syntax highlight each snippet. Existing snippets are
+-- shown as plain text.
+ALTER TABLE snippets ADD COLUMN language VARCHAR(32) NOT NULL DEFAULT 'plaintext';
@@ -0,0 +1,3 @@
+-- How each snippet's content is displayed: as preformatted text (syntax
+-- highlighted according to its language) or rendered from Markdown.
+ALTER TABLE snippets ADD COLUMN format ENUM('text', 'markdown') NOT NULL DEFAULT 'text';
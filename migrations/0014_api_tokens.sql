@@ -0,0 +1,17 @@
+-- Personal API tokens. Only a SHA-256 hash of each token is stored; the token
+-- itself is shown to the user once, when it's created. Scopes are stored as a
+-- space-separated list, as in OAuth.
+CREATE TABLE api_tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NULL,
+    last_used DATETIME NULL,
+    CONSTRAINT api_tokens_uc_token_hash UNIQUE (token_hash),
+    INDEX idx_api_tokens_user (user_id),
+    CONSTRAINT fk_api_tokens_user
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- Single-use tokens which are emailed to users, such as password reset links.
+-- Like API tokens only a SHA-256 hash is stored, and purpose stops a token
+-- issued for one thing from being used for another.
+CREATE TABLE email_tokens (
+    token_hash BINARY(32) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    INDEX idx_email_tokens_user (user_id, purpose),
+    CONSTRAINT fk_email_tokens_user
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Incremented whenever all of a user's sessions should be logged out, such
+-- as when their password is reset. Each session records the version it was
+-- logged in with.
+ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;
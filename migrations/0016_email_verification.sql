@@ -0,0 +1,6 @@
+-- Whether each user has proved that they own their email address. Accounts
+-- created before verification was introduced are treated as verified, so
+-- that they aren't suddenly locked out of creating snippets.
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE users SET email_verified = TRUE;
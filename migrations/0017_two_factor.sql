@@ -0,0 +1,16 @@
+-- Optional TOTP two-factor authentication. totp_secret is only set once the
+-- user has confirmed that their authenticator app works, and
+-- totp_last_counter records the time step of the last code used, so that a
+-- code can't be used twice.
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
+
+-- One-time recovery codes for users who have lost their authenticator app.
+-- Only a SHA-256 hash of each code is stored.
+CREATE TABLE recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash BINARY(32) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    CONSTRAINT fk_recovery_codes_user
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+-- Counts of recent failed logins for each email address and IP address, used
+-- to slow down password guessing. Rows are deleted after a successful login
+-- or once they're old enough to be forgotten. Keys such as "account:" plus an
+-- email address can be longer than a VARCHAR(255), so they're stored as the
+-- SHA-256 hash of the key.
+CREATE TABLE login_failures (
+    key_hash BINARY(32) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    INDEX idx_login_failures_last (last_failure)
+);
+
+-- An audit log of every failed login. The user_id is filled in when the
+-- email address belongs to a user, and kept even if it doesn't so that
+-- attempts on unknown accounts can be seen too.
+CREATE TABLE login_audit (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    email VARCHAR(255) NOT NULL,
+    user_id INTEGER NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    created DATETIME NOT NULL,
+    INDEX idx_login_audit_user (user_id, created),
+    INDEX idx_login_audit_created (created),
+    CONSTRAINT fk_login_audit_user
+        FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);